@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// canonicalize makes the flattener pass each top-level value to the
+// callback whole, in the canonical form of RFC 8785 (JSON
+// Canonicalization Scheme), rather than flattening it. It implies
+// validateStrings, as canonical values must be valid.
+func canonicalize(f *flattener) {
+	f.canonical = true
+	f.l.validate = true
+}
+
+// member is an object member, with its value in canonical form.
+type member struct {
+	key   string   // Decoded.
+	key16 []uint16 // The key in UTF-16, for sorting.
+	value []byte
+}
+
+// canonicalValue is like copyValue, but it writes the value in
+// canonical form: no whitespace, object members sorted by their keys
+// as UTF-16 code units, strings with only the escapes needed, and
+// numbers as ECMAScript would write them, after rounding them to the
+// nearest double. Duplicate keys are an error.
+func (f *flattener) canonicalValue(b *bytes.Buffer) (errored bool) {
+	switch it := f.nextItem(); it.typ {
+	case itemError:
+		return f.errorf("canonicalValue: lexer error: %v", it.val)
+	case itemLeftCurlyBrace:
+		if f.nextItem().typ == itemRightCurlyBrace {
+			b.WriteString("{}")
+			return false
+		}
+		f.backup()
+		var members []member
+		var value bytes.Buffer
+		for {
+			it := f.nextItem()
+			if it.typ != itemQuotedString {
+				return f.errorf("canonicalValue: expected quoted string for key, got: %v", it)
+			}
+			key := unquote(it.val)
+			if it := f.nextItem(); it.typ != itemColon {
+				return f.errorf("canonicalValue: expected colon after key, got: %v", it)
+			}
+			value.Reset()
+			if f.canonicalValue(&value) {
+				return true
+			}
+			members = append(members, member{
+				key:   key,
+				key16: utf16.Encode([]rune(key)),
+				value: append([]byte(nil), value.Bytes()...),
+			})
+			it = f.nextItem()
+			if it.typ == itemRightCurlyBrace {
+				break
+			}
+			if it.typ != itemComma {
+				return f.errorf("canonicalValue: expected comma or right curly brace after key-value pair, got: %v", it)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return lessUTF16(members[i].key16, members[j].key16)
+		})
+		b.WriteByte('{')
+		for i, m := range members {
+			if i > 0 {
+				if m.key == members[i-1].key {
+					return f.errorf("canonicalValue: duplicate key %s", canonicalString(m.key))
+				}
+				b.WriteByte(',')
+			}
+			b.WriteString(canonicalString(m.key))
+			b.WriteByte(':')
+			b.Write(m.value)
+		}
+		b.WriteByte('}')
+		return false
+	case itemLeftBracket:
+		b.WriteByte('[')
+		if f.nextItem().typ == itemRightBracket {
+			b.WriteByte(']')
+			return false
+		}
+		f.backup()
+		for {
+			if f.canonicalValue(b) {
+				return true
+			}
+			it := f.nextItem()
+			if it.typ == itemRightBracket {
+				b.WriteByte(']')
+				return false
+			}
+			if it.typ != itemComma {
+				return f.errorf("canonicalValue: expected comma or right bracket after value, got: %v", it)
+			}
+			b.WriteByte(',')
+		}
+	case itemQuotedString:
+		b.WriteString(canonicalString(unquote(it.val)))
+		return false
+	case itemNumber:
+		s, ok := canonicalNumber(it.val)
+		if !ok {
+			return f.errorf("canonicalValue: number out of range: %s", it.val)
+		}
+		b.WriteString(s)
+		return false
+	case itemTrue, itemFalse, itemNull:
+		b.WriteString(it.val)
+		return false
+	default:
+		return f.errorf("canonicalValue: unexpected lexeme: %v", it)
+	}
+}
+
+func lessUTF16(a, b []uint16) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// canonicalString encodes s as a JSON string, escaping only quotes,
+// backslashes, and control characters, the latter as \b, \t, \n, \f,
+// \r, or else \u00xx.
+func canonicalString(s string) string {
+	const hex = "0123456789abcdef"
+	var b bytes.Buffer
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 0x20 {
+				b.WriteString(`\u00`)
+				b.WriteByte(hex[c>>4])
+				b.WriteByte(hex[c&0xf])
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// canonicalNumber returns the number lexeme s as ECMAScript would write
+// the nearest double, or false if it's out of the range of doubles.
+func canonicalNumber(s string) (string, bool) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", false
+	}
+	if n == 0 {
+		// Negative zero included.
+		return "0", true
+	}
+	format := byte('f')
+	if abs := math.Abs(n); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	c := strconv.FormatFloat(n, format, -1, 64)
+	if format == 'e' {
+		// ECMAScript writes, e.g., 1e-7, not 1e-07.
+		if i := len(c) - 4; c[i] == 'e' && c[i+2] == '0' {
+			c = c[:i+2] + c[i+3:]
+		}
+	}
+	return c, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		err    string
+	}{
+		{
+			input:  `{"b": [1.0, 1e21, 1e-7, -0, 0.000001, 123456789012345678901234, 1E-400], "a": true}`,
+			output: `{"a":true,"b":[1,1e+21,1e-7,0,0.000001,1.2345678901234569e+23,0]}`,
+		},
+		{
+			// Keys are sorted as UTF-16 code units: U+1F600 is
+			// 😀, which comes before U+FB01.
+			input:  `{"ﬁ": 2, "😀": 1, "é": 0, "": null}`,
+			output: `{"":null,"é":0,"😀":1,"ﬁ":2}`,
+		},
+		{
+			input:  `" \t\u0001\/\"\\é"`,
+			output: "\" \\t\\u0001/\\\"\\\\é\"",
+		},
+		{
+			// From RFC 8785, section 3.2.2.
+			input:  `{"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001], "string": "\u20ac$\u000F\u000aA'B\u0022\u005c\\\u0022\/", "literals": [null, true, false]}`,
+			output: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			input: `{"a": 1, "a": 2}`,
+			err:   `line 1, column 16 (offset 15): canonicalValue: duplicate key "a"`,
+		},
+		{
+			input: `[1e400]`,
+			err:   `line 1, column 2 (offset 1): canonicalValue: number out of range: 1e400`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			output := newFlattener(strings.NewReader(tt.input), canonicalize).collect()
+			if len(output) != 1 {
+				t.Fatalf("got %d pairs, want 1", len(output))
+			}
+			if tt.err != "" {
+				if output[0].err == nil {
+					t.Fatalf("got nil, want %q", tt.err)
+				}
+				if got := output[0].err.Error(); got != tt.err {
+					t.Errorf("got %q, want %q", got, tt.err)
+				}
+				return
+			}
+			if output[0].err != nil {
+				t.Fatal(output[0].err)
+			}
+			if got := output[0].value; got != tt.output {
+				t.Errorf("got %s, want %s", got, tt.output)
+			}
+		})
+	}
+}
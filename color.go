@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Color modes, see the -color flag and the JF_COLOR environment
+// variable.
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI escape sequences for the colors of the parts of paths and of
+// the values of each type.
+const (
+	colorReset  = "\x1b[0m"
+	colorKey    = "\x1b[34m" // Blue.
+	colorIndex  = "\x1b[35m" // Magenta.
+	colorString = "\x1b[32m" // Green.
+	colorNumber = "\x1b[36m" // Cyan.
+	colorBool   = "\x1b[33m" // Yellow.
+	colorNull   = "\x1b[90m" // Grey.
+)
+
+// useColor decides whether to color the output. The mode comes from
+// the -color flag, if given, or else from the JF_COLOR environment
+// variable. In auto mode, output is colored only if it goes to a
+// terminal, and the NO_COLOR environment variable isn't set (see
+// https://no-color.org/).
+func useColor(mode string, out *os.File) (bool, error) {
+	if mode == "" {
+		mode = os.Getenv("JF_COLOR")
+	}
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto, "":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		fi, err := out.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return fi.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("unknown color mode %q, want one of: %s, %s, %s", mode, colorAuto, colorAlways, colorNever)
+	}
+}
+
+// colorStyle colors the paths of another style: each segment added
+// by the other style, separator included, is colored as a key or as
+// an index.
+type colorStyle struct {
+	pathStyle
+}
+
+func (cs colorStyle) key(parent string, key string) string {
+	return parent + colorKey + cs.pathStyle.key(parent, key)[len(parent):] + colorReset
+}
+
+func (cs colorStyle) index(parent string, index int) string {
+	return parent + colorIndex + cs.pathStyle.index(parent, index)[len(parent):] + colorReset
+}
+
+func (cs colorStyle) wildcard(parent string, placeholder string) string {
+	return parent + colorIndex + cs.pathStyle.wildcard(parent, placeholder)[len(parent):] + colorReset
+}
+
+// colorValue returns the value of p, colored according to its type.
+// Containers are left alone.
+func colorValue(p pair) string {
+	var color string
+	switch p.typ {
+	case itemQuotedString:
+		color = colorString
+	case itemNumber:
+		color = colorNumber
+	case itemTrue, itemFalse:
+		color = colorBool
+	case itemNull:
+		color = colorNull
+	default:
+		return p.value
+	}
+	return color + p.value + colorReset
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestColorStyle(t *testing.T) {
+	input := `{"fruit": [{"name": "banana", "n": 1, "b": true, "x": null}]}`
+	var lines []string
+	for _, p := range newFlattener(strings.NewReader(input), withPathStyle(colorStyle{jfStyle{}})).collect() {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		lines = append(lines, p.path+"\t"+colorValue(p))
+	}
+	want := []string{
+		".\t{}",
+		".\x1b[34m\"fruit\"\x1b[0m\t[]",
+		".\x1b[34m\"fruit\"\x1b[0m\x1b[35m[0]\x1b[0m\t{}",
+		".\x1b[34m\"fruit\"\x1b[0m\x1b[35m[0]\x1b[0m\x1b[34m.\"name\"\x1b[0m\t\x1b[32m\"banana\"\x1b[0m",
+		".\x1b[34m\"fruit\"\x1b[0m\x1b[35m[0]\x1b[0m\x1b[34m.\"n\"\x1b[0m\t\x1b[36m1\x1b[0m",
+		".\x1b[34m\"fruit\"\x1b[0m\x1b[35m[0]\x1b[0m\x1b[34m.\"b\"\x1b[0m\t\x1b[33mtrue\x1b[0m",
+		".\x1b[34m\"fruit\"\x1b[0m\x1b[35m[0]\x1b[0m\x1b[34m.\"x\"\x1b[0m\t\x1b[90mnull\x1b[0m",
+	}
+	if got, want := strings.Join(lines, "\n"), strings.Join(want, "\n"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := displayWidth(lines[3][:strings.IndexByte(lines[3], '\t')]), len(`."fruit"[0]."name"`); got != want {
+		t.Errorf("got %d, want %d for the width of a colored path", got, want)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	for _, name := range []string{"JF_COLOR", "NO_COLOR"} {
+		if old, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+	tests := []struct {
+		mode    string
+		env     string
+		noColor string
+		color   bool
+		err     bool
+	}{
+		{mode: "", color: false},
+		{mode: colorAuto, color: false},
+		{mode: colorNever, color: false},
+		{mode: colorAlways, color: true},
+		{mode: colorAlways, noColor: "1", color: true},
+		{env: colorAlways, color: true},
+		{mode: colorNever, env: colorAlways, color: false},
+		{mode: "sometimes", err: true},
+	}
+	for _, tt := range tests {
+		os.Setenv("JF_COLOR", tt.env)
+		os.Setenv("NO_COLOR", tt.noColor)
+		color, err := useColor(tt.mode, w)
+		if (err != nil) != tt.err {
+			t.Errorf("got %v, want error: %t", err, tt.err)
+		}
+		if color != tt.color {
+			t.Errorf("got %t, want %t for mode %q, JF_COLOR %q, NO_COLOR %q", color, tt.color, tt.mode, tt.env, tt.noColor)
+		}
+	}
+}
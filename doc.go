@@ -9,6 +9,10 @@ Command jf is a JSON flattener, which composes well with existing system tools,
 	."fruit"[1]        {}
 	."fruit"[1]."name" "apple"
 
+The examples pipe the output into tab, which aligns columns. Without tab, use -a, which pads paths with spaces to the width of the widest one, measured in terminal columns. To keep the output streaming, -a only looks ahead a window of 1000 lines (change it with -window), and aligns each window on its own.
+
+When writing to a terminal, jf colors keys, indices, and values, according to their types. Use -color always or -color never to choose, or set JF_COLOR to always, never, or auto (the default). In auto mode, setting NO_COLOR turns colors off. Output with -0, -csv, or -j is never colored.
+
 There's a (simpler and faster) version in C (for Plan 9 and p9p) in the 9 subdirectory. It's easier to install the Go version, though.
 
 Motivation: I wanted a simple and composable tool that would take advantage of other software already in the system (following the UNIX philosophy), for exploring and comparing JSON documents. Also, as a learning exercise in lexing/parsing.
@@ -71,16 +75,17 @@ A concrete example:
 	+."details"              "First Dragon spacecraft"
 	 ."reuse_count"          0
 
-Have object keys been reordered from one document to the other? No need to add features to jf, just bring sort to the mix:
+Have object keys been reordered from one document to the other? With -s, jf prints the keys of objects in sorted order, by their decoded strings:
+
+	diff -u <(jf -s <before.json) <(jf -s <after.json)
 
-	diff -u (sort <before.json | jf) <(sort <after.json | jf)
+The values of duplicate keys are printed whole, one after the other, in the order they come. As the objects printed whole by -d would keep their order, -s can't be used with -d. Sorting means holding on to the pairs of an object until it ends, but not to those of the elements of top-level arrays, which are printed as they come. When what's held on to gets bigger than what -sortmem says, it goes to temporary files.
 
-Have array items been reordered from one document to the other? No need to add features to jf, just bring sed to the mix:
+Have array items been reordered from one document to the other? With -w, jf prints * instead of array indices, and with -sort it sorts the pairs of each value by path, then by value, so the two documents can be compared as multisets of pairs:
 
-	function prep() {
-		cat $1 | sed -E 's/\[[0-9]+\]/[number]/g' | sort
-	}
-	diff -u <(prep before.json) <(prep after.json)
+	diff -u <(jf -w -sort <before.json) <(jf -w -sort <after.json)
+
+With -warrays, which can be repeated, only the indices of the arrays whose paths match a pattern (see -i below) are replaced, e.g., -warrays '."tags"' for an array whose order doesn't matter, in a document where others do. With -placeholder, something else than * is printed.
 
 Want extract all SpaceX launches videos? Post-process jf's output with grep and awk.
 
@@ -127,6 +132,154 @@ With -m, jf will flatten many JSON elements but paths may be duplicated as a res
 	."type"                 "Dragon 1.0"
 	."type"                 "Dragon 1.0"
 
+With -e continue, which implies -m, a malformed value doesn't stop jf. Nothing is printed for it: the error is reported, and flattening resumes at the next top-level value, once the objects and arrays open in the malformed one are closed. A count of good and bad records is printed at the end. With -k, meant for newline-delimited JSON (NDJSON) such as log files, jf keeps going in the same way, but each line is a record: a value must end on the line it starts on, followed by nothing but other values. After an error, the rest of the line is skipped, and flattening resumes from the next line, unless that line only continues a value that didn't end on its line, as when it's pretty-printed: such lines are part of the malformed value, up to the one closing its objects and arrays. That way, a truncated record doesn't swallow the ones after it. Adding -strict helps, as it stops strings at raw newlines, which are not allowed in JSON strings anyway.
+
+	; printf '{"a":1}\n{"a": tru}\n{"a":3}\n' | jf -k
+	.	{}
+	."a"	1
+	2020/06/01 18:56:21 jf: line 2, column 7 (offset 14): skipping value starting at line 2: flattenValue: lexer error: bad literal "tru": expected true, false, or null
+	{"a": tru}
+	      ^
+	.	{}
+	."a"	3
+	2020/06/01 18:56:21 jf: 2 good records, 1 bad records
+
+The exit status is 0 on success, 1 if the input was malformed (even if jf kept going), 2 for usage errors, and 3 if the output, or the temporary files used for sorting, could not be written. A broken pipe is not an error: jf just stops, quietly. With -json-errors, errors and the summary are written to standard error as JSON objects, one per line, for scripts to parse:
+
+	; printf '{"a":1}\n{"a": tru}\n' | jf -k -json-errors >/dev/null
+	{"type":"syntax","message":"skipping value starting at line 2: flattenValue: lexer error: bad literal \"tru\": expected true, false, or null","line":2,"column":7,"offset":14}
+	{"type":"summary","good":1,"bad":1}
+
+Numbers, true, false, and null are checked against the grammar in RFC 8259. Strings are only checked to be terminated, unless -strict is given, in which case escape sequences, surrogate pairs, control characters, and UTF-8 encoding are validated too:
+
+	; echo '{"a": "\q"}' | jf -strict
+	.	{}
+	2020/06/01 18:56:21 jf: line 1, column 8 (offset 7): flattenValue: lexer error: invalid escape sequence \q
+	{"a": "\q"}
+	       ^
+
+Errors give the line, the column (counting runes), and the byte offset where they occur, followed by an excerpt of the input line with a caret under that position.
+
+With -P, paths are printed as JSON Pointers (RFC 6901) instead, as used by many HTTP APIs to report validation errors. Keys are decoded, and ~ and / in them are escaped as ~0 and ~1. The root pointer is the empty string:
+
+	; echo '{"fruit":[{"name":"banana"}],"a/b":1}' | jf -P
+		{}
+	/fruit	[]
+	/fruit/0	{}
+	/fruit/0/name	"banana"
+	/a~1b	1
+
+With -g, each pair is printed as a JavaScript assignment, as in gron. Keys that are identifiers are written after a dot, others in brackets. The output is as easy to grep, and it can be pasted into node, or the paths into jq:
+
+	; echo '{"fruit":[{"name":"banana","best before":null}]}' | jf -g
+	json = {};
+	json.fruit = [];
+	json.fruit[0] = {};
+	json.fruit[0].name = "banana";
+	json.fruit[0]["best before"] = null;
+
+With -0, the path and the value are each followed by a NUL byte instead of a tab or a newline, and strings are decoded, e.g., \t in a JSON string becomes a tab. Keys in paths are decoded too, and lose their quotes, e.g., .fruit[0].name, unless -P is also given. That's for xargs -0, or read -d '' loops, that want the actual strings, even when they contain tabs or newlines:
+
+	; echo '{"files":["a b.txt","c\td.txt"]}' | jf -0 -t string | xargs -0 -n 2 printf '%s => [%s]\n'
+	.files[0] => [a b.txt]
+	.files[1] => [c	d.txt]
+
+With -csv, pairs are printed as CSV records (RFC 4180), after a header record, with strings decoded, for spreadsheets and other data tools. With -columns, any of type, doc (the index of the value in a -m stream, from 0), and depth (the number of keys and indices in the path) are added as extra columns:
+
+	; echo '{"name":"Smith, John","tags":["a"]}' | jf -csv -columns type,depth
+	path,value,type,depth
+	.,{},object,0
+	".""name""","Smith, John",string,1
+	".""tags""",[],array,1
+	".""tags""[0]",a,string,2
+
+With -j, each pair is printed as a JSON object on a line of its own (JSON Lines), with the path as an array of decoded keys and indices, the path as a JSON Pointer, the type, and the value, decoded. That's a typed, unambiguous stream for other programs, e.g., log pipelines:
+
+	; echo '{"fruit":[{"name":"banana"}]}' | jf -j | sed -n 4p
+	{"path":["fruit",0,"name"],"pointer":"/fruit/0/name","type":"string","value":"banana"}
+
+With -T, jf prints the JSON type of each value (object, array, string, number, boolean, or null) in a third column. With -t, it only prints values of the given types:
+
+	; curl -sL https://api.spacexdata.com/v3/launches/latest | jf -t null | sed 3q
+	."details"	null
+	."links"."reddit_recovery"	null
+	."links"."presskit"	null
+
+With -l, jf only prints leaves: scalars, and objects and arrays that are empty, so nothing is lost. The {} and [] lines of other containers are left out, except for those of top-level values when decoding many, which tell where each value starts. There's no need to filter marker lines out with grep -v, and the output still unflattens with -r:
+
+	; echo '{"a":[1,{"b":[]}],"c":{}}' | jf -l
+	."a"[0]	1
+	."a"[1]."b"	[]
+	."c"	{}
+
+With -c, jf also prints a line at the end of each object and array, after those of its contents, with } or ] followed by the number of keys or elements. That answers how long an array is without counting lines:
+
+	; echo '{"items":[1,2,3]}' | jf -c | awk -F'\t' '$2 ~ /^]/'
+	."items"	]3
+
+With -i and -x, jf only prints the values whose paths match a pattern, and their contents, or leaves out those that match, and their contents. Both can be repeated, and -x wins over -i. Patterns are written like paths, and can also contain .* for any key, [*] for any index, [i:j] for indices from i up to j excluded (either can be left out), and ** for any number of keys and indices:
+
+	; echo '{"items":[{"login":"x","url":"u"},{"login":"y"}]}' | jf -i '."items"[*]."login"'
+	."items"[0]."login"	"x"
+	."items"[1]."login"	"y"
+	; echo '{"items":[{"login":"x","url":"u"},{"login":"y"}]}' | jf -x '**."url"' -l
+	."items"[0]."login"	"x"
+	."items"[1]."login"	"y"
+
+The values that can't match are skipped as they're read, which is much faster than filtering the output with grep.
+
+With -value-match, jf only prints the scalars whose values match a regular expression, strings being decoded first, so \t matches a tab. With -where, it only prints the numbers for which a comparison holds, e.g., 'num > 100'; numbers are compared exactly, however many digits they have, but those with exponents over a million never match. With -ancestors, the objects and arrays containing the values printed are printed too, before them:
+
+	; echo '{"items":[{"login":"x","stars":150},{"login":"y","stars":5}]}' | jf -where 'num >= 100' -ancestors
+	.	{}
+	."items"	[]
+	."items"[0]	{}
+	."items"[0]."stars"	150
+
+With -p, jf only prints the value at the given path, and its contents, and exits as soon as it's read that value, so getting a value near the start of a huge document is quick. With -relative, paths are relative to that value:
+
+	; echo '{"items":[{"login":"x"},{"login":"y","id":2}]}' | jf -p '."items"[1]' -relative
+	.	{}
+	."login"	"y"
+	."id"	2
+
+With -m, the value is printed for each of the values read, so all of the input is read.
+
+With -d N, jf stops descending at paths with N segments: objects and arrays found there are printed whole, minified, on one line. That's a quick way to get an overview of a big document:
+
+	; echo '{"items":[{"login":"x","id":1}],"total":1}' | jf -d 2
+	.	{}
+	."items"	[]
+	."items"[0]	{"login":"x","id":1}
+	."total"	1
+
+As what's below depth N isn't flattened, -d can't be used with -i and -x, whose patterns might reach below it.
+
+The sort subcommand sorts flattened output, read from standard input, the way -s would have printed it: indices in numerical order, so [2] comes before [10], keys by their decoded strings, and containers before their contents. Lines with the root path start new values, which are kept in the order they come, and so are lines with the same path. The input must be in the order jf printed it: sort(1), for one, would mix up the values of output from -m. Input bigger than what -sortmem says goes to temporary files, so there's no limit to what can be sorted:
+
+	; jf sort <a.flat >sorted.flat
+
+With -n, numbers are printed in a canonical decimal form, so that equal numbers are printed the same way, e.g., 1.0, 1e0, and 10E-1 are all printed as 1, and comparing documents doesn't turn up spurious differences. No digits are lost, however many there are. Numbers from 0.000001 up to 1e21 are printed without exponent, the others with one digit before the point, e.g., 1.5e+21. With -keep-integers, numbers without fraction and exponent are printed as they are written, e.g., long identifiers:
+
+	; echo '[1.0, 10E-1, 2.50, 1e21, 123456789012345678901234]' | jf -n -keep-integers -l
+	.[0]	1
+	.[1]	1
+	.[2]	2.5
+	.[3]	1e+21
+	.[4]	123456789012345678901234
+
+With -jcs, jf prints each value in the canonical form of RFC 8785 (JSON Canonicalization Scheme), one per line, instead of flattening it: no whitespace, object keys sorted, strings with only the escapes needed, and numbers as JavaScript would print them. Duplicate keys, invalid strings, and numbers too big for a double are errors. Equal values have equal canonical forms, byte for byte, so they can be hashed and signed:
+
+	; echo '{"b": 1.50, "a": "\u00e9"}' | jf -jcs
+	{"a":"é","b":1.5}
+
+With -r, jf goes the other way: it reads path-value pairs and prints the JSON values they came from, one per line, with object keys in input order. That means flattened output can be edited with the usual tools and turned back into JSON:
+
+	; echo '{"fruit":[{"name":"banana"},{"name":"apple"}]}' | jf | sed 's/banana/cherry/' | jf -r
+	{"fruit":[{"name":"cherry"},{"name":"apple"}]}
+
+A pair whose path is the root path starts a new value, so the output of jf -m unflattens to many values. Container lines ({} and []) can be left out, as long as their kind can be told from the paths that follow.
+
 The input to jf is a stream, and the output is incremental, so that jf works
 well in a pipeline. For example, in a pipeline like
 
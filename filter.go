@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// valueFilter filters pairs on their values. Predicates only apply to
+// scalars, and to the objects and arrays printed whole with -d: pairs
+// for other objects and arrays, and for their ends, are left out,
+// unless they're the ancestors of a pair that's kept and ancestors
+// are wanted.
+type valueFilter struct {
+	preds     []func(p pair) bool
+	ancestors bool
+	stack     []pair // Containers of the last pair.
+	written   int    // Number of pairs in stack written already.
+	out       []pair
+}
+
+// filter returns the pairs to write for p: none, p alone, or p
+// preceded by those of its ancestors that haven't been written yet.
+func (vf *valueFilter) filter(p pair) []pair {
+	switch p.typ {
+	case itemRightCurlyBrace, itemRightBracket:
+		return nil
+	}
+	if vf.ancestors {
+		// Forget the containers p isn't in.
+		n := len(vf.stack)
+		for n > 0 && (vf.stack[n-1].depth >= p.depth || vf.stack[n-1].doc != p.doc) {
+			n--
+		}
+		vf.stack = vf.stack[:n]
+		if vf.written > n {
+			vf.written = n
+		}
+	}
+	if isContainer(p) {
+		if vf.ancestors {
+			p.segs = append([]segment(nil), p.segs...)
+			vf.stack = append(vf.stack, p)
+		}
+		return nil
+	}
+	for _, pred := range vf.preds {
+		if !pred(p) {
+			return nil
+		}
+	}
+	vf.out = append(vf.out[:0], vf.stack[vf.written:]...)
+	vf.written = len(vf.stack)
+	return append(vf.out, p)
+}
+
+// isContainer tells whether p is for an object or an array, whose
+// contents have pairs of their own.
+func isContainer(p pair) bool {
+	switch p.typ {
+	case itemLeftCurlyBrace, itemLeftBracket:
+		return p.value == "{}" || p.value == "[]"
+	default:
+		return false
+	}
+}
+
+// matchValue returns a predicate that holds for the pairs whose values
+// match re. Strings are decoded first.
+func matchValue(re *regexp.Regexp) func(p pair) bool {
+	return func(p pair) bool {
+		return re.MatchString(decodedValue(p))
+	}
+}
+
+// whereRegexp matches the expressions given to -where, e.g., num > 100.
+var whereRegexp = regexp.MustCompile(`^\s*num\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// parseWhere parses an expression comparing numbers to a constant,
+// e.g., num > 100, and returns a predicate that holds for the pairs
+// whose values are numbers for which the comparison is true.
+// Operators are <, <=, ==, !=, >=, and >. Numbers are compared
+// exactly, except those with exponents so big that they aren't
+// compared at all, see big.Rat.SetString.
+func parseWhere(expr string) (func(p pair) bool, error) {
+	m := whereRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("bad expression %q, want num followed by one of <, <=, ==, !=, >=, > and a number", expr)
+	}
+	op := m[1]
+	operand, ok := parseNumber(m[2])
+	if !ok || !isNumber(m[2]) {
+		return nil, fmt.Errorf("bad number %q in expression %q", m[2], expr)
+	}
+	return func(p pair) bool {
+		if p.typ != itemNumber {
+			return false
+		}
+		n, ok := parseNumber(p.value)
+		if !ok {
+			return false
+		}
+		c := n.Cmp(operand)
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case "==":
+			return c == 0
+		case "!=":
+			return c != 0
+		case ">=":
+			return c >= 0
+		default:
+			return c > 0
+		}
+	}, nil
+}
+
+func parseNumber(s string) (*big.Rat, bool) {
+	return new(big.Rat).SetString(s)
+}
+
+// isNumber tells whether s is a JSON number, as big.Rat.SetString
+// also takes, e.g., 1/3 and 0x1p-2.
+func isNumber(s string) bool {
+	l := newLexer(strings.NewReader(s))
+	it := l.nextItem()
+	return it.typ == itemNumber && it.val == s && l.nextItem().typ == itemEOF
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValueFilter(t *testing.T) {
+	input := `{"items": [{"login": "a\tb", "n": 150}, {"login": "c", "n": 50, "t": [200]}], "total": 1e3} {"n": 101}`
+	where := func(expr string) func(p pair) bool {
+		pred, err := parseWhere(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pred
+	}
+	tests := []struct {
+		preds     []func(p pair) bool
+		ancestors bool
+		output    []string
+	}{
+		{
+			preds:  []func(p pair) bool{where("num > 100")},
+			output: []string{`."items"[0]."n"`, `."items"[1]."t"[0]`, `."total"`, `."n"`},
+		},
+		{
+			preds:     []func(p pair) bool{where("num > 100")},
+			ancestors: true,
+			output: []string{
+				`.`, `."items"`, `."items"[0]`, `."items"[0]."n"`,
+				`."items"[1]`, `."items"[1]."t"`, `."items"[1]."t"[0]`,
+				`."total"`,
+				`.`, `."n"`,
+			},
+		},
+		{
+			preds:  []func(p pair) bool{where("num == 1000")},
+			output: []string{`."total"`},
+		},
+		{
+			preds:  []func(p pair) bool{where("num<=50.0")},
+			output: []string{`."items"[1]."n"`},
+		},
+		{
+			// Strings are decoded.
+			preds:     []func(p pair) bool{matchValue(regexp.MustCompile("^a\tb$"))},
+			ancestors: true,
+			output:    []string{`.`, `."items"`, `."items"[0]`, `."items"[0]."login"`},
+		},
+		{
+			preds:  []func(p pair) bool{matchValue(regexp.MustCompile("0$")), where("num != 150")},
+			output: []string{`."items"[1]."n"`, `."items"[1]."t"[0]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			vf := &valueFilter{preds: tt.preds, ancestors: tt.ancestors}
+			var output []string
+			newFlattener(strings.NewReader(input), acceptMany, withSizes).run(func(p pair) {
+				if p.err != nil {
+					t.Fatal(p.err)
+				}
+				for _, p := range vf.filter(p) {
+					output = append(output, p.path)
+				}
+			})
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestParseWhereExact(t *testing.T) {
+	// 10^321 + 1, which needs more than 1000 bits.
+	huge := "1" + strings.Repeat("0", 320) + "1"
+	tests := []struct {
+		expr  string
+		value string
+		want  bool
+	}{
+		{expr: "num == 1e321", value: huge, want: false},
+		{expr: "num > 1e321", value: huge, want: true},
+		{expr: "num == 1e321", value: "10E+320", want: true},
+		{expr: "num < 0.3", value: "0.30000000000000001", want: false},
+		{expr: "num == -0", value: "0", want: true},
+	}
+	for _, tt := range tests {
+		pred, err := parseWhere(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pred(pair{value: tt.value, typ: itemNumber}); got != tt.want {
+			t.Errorf("%s with %s: got %v, want %v", tt.expr, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "n > 1", err: `bad expression "n > 1", want num followed by one of <, <=, ==, !=, >=, > and a number`},
+		{expr: "num = 1", err: `bad expression "num = 1", want num followed by one of <, <=, ==, !=, >=, > and a number`},
+		{expr: "num > x", err: `bad number "x" in expression "num > x"`},
+		{expr: "num > inf", err: `bad number "inf" in expression "num > inf"`},
+		{expr: "num > 0x10", err: `bad number "0x10" in expression "num > 0x10"`},
+		{expr: "num > 1/3", err: `bad number "1/3" in expression "num > 1/3"`},
+		{expr: "num > 01", err: `bad number "01" in expression "num > 01"`},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			_, err := parseWhere(tt.expr)
+			if err == nil {
+				t.Fatalf("got nil, want %q", tt.err)
+			}
+			if got := err.Error(); got != tt.err {
+				t.Errorf("got %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
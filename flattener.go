@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type pair struct {
 	path  string
 	value string
-	err   error
+	typ   itemType // The item type of the value, or of the opening brace or bracket for containers.
+	doc   int      // Index of the top-level value the pair belongs to, starting from 0.
+	depth int      // Number of segments in the path.
+	// The segments of the path. Only valid during the callback, as
+	// the flattener reuses the slice.
+	segs []segment
+	err  error
 }
 
 type option func(*flattener)
@@ -17,6 +27,133 @@ func acceptMany(f *flattener) {
 	f.many = true
 }
 
+// withPathStyle makes the flattener build paths in the given style.
+func withPathStyle(style pathStyle) option {
+	return func(f *flattener) {
+		f.style = style
+	}
+}
+
+// maxDepth makes the flattener stop descending into containers whose
+// paths have n segments: such containers are passed to the callback
+// whole, as minified JSON, rather than flattened.
+func maxDepth(n int) option {
+	return func(f *flattener) {
+		f.maxDepth = n
+	}
+}
+
+// leavesOnly makes the flattener skip the pairs for objects and
+// arrays, except for empty ones, so that no information is lost.
+func leavesOnly(f *flattener) {
+	f.leaves = true
+}
+
+// withSizes makes the flattener emit a pair at the end of each
+// object and array, after those of its contents, whose value tells
+// the number of keys or elements, e.g., ]3. The item type of such
+// pairs is that of the closing brace or bracket.
+func withSizes(f *flattener) {
+	f.sizes = true
+}
+
+// include makes the flattener emit only the pairs of values whose
+// paths match p, and of their contents. If given many times, paths
+// need to match any of the patterns. Values that can't contain
+// matching values aren't flattened at all.
+func include(p pattern) option {
+	return func(f *flattener) {
+		f.includes = append(f.includes, p)
+	}
+}
+
+// exclude makes the flattener skip values whose paths match p, and
+// their contents, even if they match an include pattern.
+func exclude(p pattern) option {
+	return func(f *flattener) {
+		f.excludes = append(f.excludes, p)
+	}
+}
+
+// extract makes the flattener only flatten the value at the path
+// made of target, and its contents, and stop reading the input as soon
+// as that's done, unless flattening many values. If relative is true,
+// paths are relative to that value, e.g., the value itself is at the
+// root path. It can't be used together with include.
+func extract(target []segment, relative bool) option {
+	return func(f *flattener) {
+		f.includes = append(f.includes, exactPattern(target))
+		f.target = target
+		f.extract = true
+		f.relative = relative
+	}
+}
+
+// withWildcards makes the flattener write placeholder in paths
+// instead of array indices, e.g., ."fruit"[*]."name", so that the
+// pairs don't depend on the order of array elements. If any patterns
+// are given, only the indices of arrays whose paths match any of them
+// are replaced. The segments passed to the callback keep the indices.
+func withWildcards(placeholder string, patterns ...pattern) option {
+	return func(f *flattener) {
+		f.wildcards = true
+		f.placeholder = placeholder
+		f.wildcardPatterns = patterns
+	}
+}
+
+// normalizeNumbers makes the flattener rewrite numbers in canonical
+// decimal form, as normalizeNumber does, so that numbers that are
+// equal have equal values in pairs. If keepIntegers is true, integers
+// are kept as written.
+func normalizeNumbers(keepIntegers bool) option {
+	return func(f *flattener) {
+		f.normalize = true
+		f.keepIntegers = keepIntegers
+	}
+}
+
+// numberKeys makes the flattener number the occurrences of the keys
+// of each object in the indices of their segments, as keyOccurrences
+// does, so that the values of duplicate keys can be told apart when
+// sorting, even if the pairs of the objects are then filtered out.
+func numberKeys(f *flattener) {
+	f.keyNumbers = true
+}
+
+// recoverErrors makes the flattener skip malformed values, rather
+// than stopping at the first one, and implies acceptMany. After an
+// error, flattening resumes after the objects and arrays open in the
+// malformed value are closed, i.e., at the next top-level value. The
+// pairs of each value are held on to until the value is complete, so
+// that none are passed to the callback for malformed values.
+func recoverErrors(f *flattener) {
+	f.many = true
+	f.recover = true
+}
+
+// lineRecords is like recoverErrors, but for streams of
+// newline-delimited values (NDJSON), where each line is a record:
+// values must end on the line they start on, and only be followed by
+// other values on it. A value that doesn't end on its line is cut
+// there, and the next one starts on the next line. Lines that look
+// like they continue a value cut earlier, e.g., starting with a key or
+// a closing bracket, are taken as part of it, and not reported, until
+// its objects and arrays are closed, and a value between them is only
+// kept if they don't close them. After any other error, flattening
+// resumes at the next line.
+func lineRecords(f *flattener) {
+	recoverErrors(f)
+	f.lines = true
+}
+
+// validateStrings makes the flattener reject quoted strings with
+// invalid escape sequences, unpaired surrogates, control characters,
+// or invalid UTF-8.
+func validateStrings(f *flattener) {
+	f.l.validate = true
+}
+
 // flattener is a recursive-descent parser that produces, instead of
 // a parse tree, a sequence of pathname-value pairs. Example:
 //
@@ -63,12 +200,58 @@ type flattener struct {
 	last   item // Last item got from the lexer.
 	repeat bool // Whether fetching the next item returns last or reads a new one from the lexer.
 	many   bool // Decode only one value or many?
-	cb     func(path string, value string, err error)
+	cb     func(p pair)
+	style  pathStyle
+	segs   []segment // Path of the value being flattened.
+	doc    int       // Index of the top-level value being flattened.
+
+	includes []pattern // See the include option.
+	excludes []pattern // See the exclude option.
+	sel      selection // Of the value being flattened.
+	target   []segment // See the extract option.
+	extract  bool
+	relative bool
+	maxDepth int // See the maxDepth option, negative for no limit.
+
+	// See the withWildcards option.
+	wildcards        bool
+	placeholder      string
+	wildcardPatterns []pattern
+
+	leaves     bool // See the leavesOnly option.
+	sizes      bool // See the withSizes option.
+	canonical  bool // See the canonicalize option.
+	keyNumbers bool // See the numberKeys option.
+	compact    bytes.Buffer
+
+	// See the normalizeNumbers option.
+	normalize    bool
+	keepIntegers bool
+
+	// For error recovery, see recoverErrors.
+	recover bool
+	lines   bool     // See lineRecords.
+	start   position // Where the value being flattened starts.
+	good    int      // Number of values flattened.
+	bad     int      // Number of values skipped because malformed.
+	inValue bool     // Whether the value being flattened has started.
+	open    int      // Objects and arrays opened in it, less those closed.
+	cut     *item    // First item of the next line, if it was cut.
+	cutOff  bool     // Whether it was malformed only for being cut.
+	stray   bool     // Whether it looks like it continues a value cut earlier.
+	failure error    // What made it malformed.
+	held    []pair   // Pairs of the value being flattened.
+	cont    int      // Objects and arrays left open by a value cut earlier.
+	maybe   []pair   // Pairs of a value that might only continue it.
+
+	stopped bool // See stop.
 }
 
 func newFlattener(r io.Reader, opts ...option) *flattener {
 	f := &flattener{
-		l: newLexer(r),
+		l:        newLexer(r),
+		style:    jfStyle{},
+		maxDepth: -1,
 	}
 	for _, o := range opts {
 		o(f)
@@ -76,13 +259,46 @@ func newFlattener(r io.Reader, opts ...option) *flattener {
 	return f
 }
 
-func (f *flattener) run(cb func(path string, value string, err error)) {
+func (f *flattener) run(cb func(p pair)) {
 	f.cb = cb
-	for {
-		// Don't care for the return value here, that's only used to
-		// interrupt the recursive descent. Any error will get to the
-		// consumer via NextPair.
-		_ = f.flattenValue(".")
+	for ; ; f.doc++ {
+		first := f.nextItem()
+		f.start = first.pos
+		f.open = opens(first.typ)
+		if f.lines {
+			switch first.typ {
+			case itemColon, itemComma, itemRightCurlyBrace, itemRightBracket:
+				f.stray = true
+			}
+		}
+		f.backup()
+		f.segs = f.segs[:0]
+		// Any error has been passed to the callback already, the
+		// return value is only used to interrupt the recursive
+		// descent.
+		f.sel = selectAll
+		if len(f.includes) > 0 {
+			f.sel = selectSome
+		}
+		f.inValue = true
+		errored := f.flattenChild("")
+		if !errored && f.lines {
+			errored = f.endLine()
+		}
+		if errored && f.recover && !f.stopped {
+			f.resync()
+		}
+		f.inValue = false
+		if f.recover {
+			f.settle(errored)
+		}
+		if f.stopped {
+			break
+		}
+		if errored && !f.recover {
+			f.bad++
+			break
+		}
 		if it := f.nextItem(); it.typ == itemEOF {
 			break
 		} else if !f.many {
@@ -92,20 +308,164 @@ func (f *flattener) run(cb func(path string, value string, err error)) {
 			f.backup()
 		}
 	}
+	if !f.stopped {
+		f.release()
+	}
+}
+
+// opens returns how much an item of type t adds to the number of
+// objects and arrays open.
+func opens(t itemType) int {
+	switch t {
+	case itemLeftCurlyBrace, itemLeftBracket:
+		return 1
+	case itemRightCurlyBrace, itemRightBracket:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// endLine checks that the value just flattened is followed by the end
+// of its line, or by another value on it.
+func (f *flattener) endLine() (errored bool) {
+	if !f.repeat && f.l.restOfLineBlank() {
+		return false
+	}
+	switch it := f.nextItem(); it.typ {
+	case itemColon, itemComma, itemRightCurlyBrace, itemRightBracket:
+		f.stray = true
+		return f.errorf("expected end of line or another value after value, got: %v", it)
+	default:
+		// Any error belongs to the next value.
+		f.backup()
+		return false
+	}
+}
+
+// resync skips input after an error, up to where the next value
+// starts: the next line, if values must end on their lines, or
+// otherwise where the objects and arrays open in the malformed value
+// are closed. Errors from the lexer are skipped over too.
+func (f *flattener) resync() {
+	f.repeat = false
+	for {
+		if f.cut != nil {
+			f.last = *f.cut
+			f.cut = nil
+			f.backup()
+			return
+		}
+		if f.last.typ == itemError {
+			f.l.resume()
+		}
+		if !f.lines && f.open <= 0 {
+			return
+		}
+		if f.nextItem().typ == itemEOF {
+			f.backup()
+			return
+		}
+	}
+}
+
+// settle passes the pairs of the value just flattened to the
+// callback, or the error that made it malformed, and counts it as
+// good or bad. If values must end on their lines, lines that only
+// continue a value that didn't aren't values of their own: see
+// lineRecords.
+func (f *flattener) settle(errored bool) {
+	held, failure, cutOff, stray := f.held, f.failure, f.cutOff, f.stray
+	f.held, f.failure, f.cutOff, f.stray = f.held[:0], nil, false, false
+	switch {
+	case errored && f.stopped:
+	case errored && stray && f.cont > 0:
+		// More of the value cut earlier.
+		if f.cont += f.open; f.cont <= 0 {
+			f.cont, f.maybe = 0, f.maybe[:0]
+		}
+	case errored:
+		f.release()
+		f.bad++
+		f.cb(pair{err: failure})
+		if cutOff && f.open > 0 {
+			f.cont = f.open
+		}
+	case f.cont > 0 && len(f.maybe) == 0:
+		// This might also be more of the value cut earlier, which
+		// the lines that follow tell.
+		f.maybe = append(f.maybe, held...)
+		f.held = held[:0]
+	default:
+		f.release()
+		f.good++
+		for _, p := range held {
+			if f.stopped {
+				return
+			}
+			f.cb(p)
+		}
+	}
+}
+
+// release passes on the pairs of the value that might have only
+// continued a value cut earlier, as it didn't, and forgets about the
+// latter.
+func (f *flattener) release() {
+	f.cont = 0
+	if len(f.maybe) == 0 {
+		return
+	}
+	f.good++
+	for _, p := range f.maybe {
+		if f.stopped {
+			break
+		}
+		f.cb(p)
+	}
+	f.maybe = f.maybe[:0]
+}
+
+// stop makes run return as soon as possible, without reading any
+// more input. It's meant to be called from the callback, e.g., when
+// the output can't be written.
+func (f *flattener) stop() {
+	f.stopped = true
+}
 
+// stats returns the number of values flattened and the number of
+// malformed values skipped.
+func (f *flattener) stats() (good, bad int) {
+	return f.good, f.bad
 }
 
 // For convenience in unit tests.
 func (f *flattener) collect() (output []pair) {
-	f.run(func(path string, value string, err error) {
-		output = append(output, pair{path: path, value: value, err: err})
+	f.run(func(p pair) {
+		p.segs = append([]segment(nil), p.segs...)
+		output = append(output, p)
 	})
 	return
 }
 
 func (f *flattener) nextItem() item {
 	if !f.repeat {
-		f.last = f.l.nextItem()
+		it := f.l.nextItem()
+		if f.lines && f.inValue && f.cut == nil && it.pos.line > f.start.line && it.typ != itemEOF {
+			// The value being flattened is cut, as values end
+			// with their lines: the error is at the end of the
+			// last item on the line.
+			f.cut = &it
+			// An object or array opened alone on a line is
+			// typical of values printed on many lines.
+			f.stray = f.stray || f.last.pos == f.start
+			end := f.last.pos
+			end.offset += int64(len(f.last.val))
+			end.column += utf8.RuneCountInString(f.last.val)
+			f.last = item{typ: itemError, pos: end}
+		} else if f.last = it; f.recover {
+			f.open += opens(f.last.typ)
+		}
 	} else {
 		f.repeat = false
 	}
@@ -117,38 +477,356 @@ func (f *flattener) backup() {
 	f.repeat = true
 }
 
+// errorf reports a syntax error at the last item got from the lexer.
 func (f *flattener) errorf(format string, a ...interface{}) (errored bool) {
-	f.cb("", "", fmt.Errorf(format, a...))
+	err := &syntaxError{
+		msg: fmt.Sprintf(format, a...),
+		pos: f.last.pos,
+	}
+	if f.cut != nil {
+		// Whatever was expected, the line ended first.
+		err.msg = "expected value to end on its line"
+		f.cutOff = true
+	} else {
+		err.excerpt, err.index, _ = f.l.excerpt(f.last.pos)
+	}
+	if f.recover {
+		// Tell which value is skipped, and report it once it's
+		// known to be a value of its own, see settle.
+		err.msg = fmt.Sprintf("skipping value starting at line %d: %s", f.start.line, err.msg)
+		f.failure = err
+		return true
+	}
+	f.cb(pair{err: err})
 	return true
 }
 
+// syntaxError is an error in the input, with its position and, if
+// available, an excerpt of the input around it.
+type syntaxError struct {
+	msg     string
+	pos     position
+	excerpt string
+	index   int // Index in excerpt of the byte at pos.
+}
+
+// Error implements error.
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("%v: %s", e.pos, e.msg)
+}
+
+// context returns the excerpt of the input and, on the line below,
+// a caret under the position of the error. Tabs in the excerpt are
+// kept in the caret line so that the caret lines up, other control
+// characters and invalid UTF-8 are replaced with a question mark.
+func (e *syntaxError) context() string {
+	if e.excerpt == "" {
+		return ""
+	}
+	var text, caret strings.Builder
+	for i, r := range e.excerpt {
+		if r == utf8.RuneError || (r < 0x20 && r != '\t') {
+			r = '?'
+		}
+		text.WriteRune(r)
+		if i < e.index {
+			if r == '\t' {
+				caret.WriteByte('\t')
+			} else {
+				caret.WriteByte(' ')
+			}
+		}
+	}
+	caret.WriteByte('^')
+	return text.String() + "\n" + caret.String()
+}
+
+// emit passes a pair for the value being flattened to the callback.
+func (f *flattener) emit(path string, value string, typ itemType) {
+	if f.sel != selectAll {
+		return
+	}
+	segs := f.segs
+	if f.relative {
+		segs = segs[len(f.target):]
+	}
+	p := pair{path: path, value: value, typ: typ, doc: f.doc, depth: len(segs), segs: segs}
+	if f.recover {
+		p.segs = append([]segment(nil), segs...)
+		f.held = append(f.held, p)
+		return
+	}
+	f.cb(p)
+}
+
+// push and pop maintain f.segs while descending into containers.
+func (f *flattener) push(seg segment) {
+	f.segs = append(f.segs, seg)
+}
+
+func (f *flattener) pop() {
+	f.segs = f.segs[:len(f.segs)-1]
+}
+
+// wildcardArray tells whether the indices of the array at the path
+// made of segs are to be replaced with the placeholder.
+func (f *flattener) wildcardArray(segs []segment) bool {
+	if len(f.wildcardPatterns) == 0 {
+		return true
+	}
+	for _, p := range f.wildcardPatterns {
+		if p.match(segs, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// selection tells which pairs of a value to emit, according to the
+// include and exclude patterns.
+type selection int
+
+const (
+	selectAll  selection = iota // The pairs of the value and of its contents.
+	selectSome                  // Only some of the pairs of its contents.
+	selectNone                  // None, the value is skipped.
+)
+
+// selectValue returns the selection for the value at f.segs, given
+// f.sel, the selection for its container.
+func (f *flattener) selectValue() selection {
+	for _, p := range f.excludes {
+		if p.match(f.segs, false) {
+			return selectNone
+		}
+	}
+	if f.sel == selectAll {
+		return selectAll
+	}
+	sel := selectNone
+	for _, p := range f.includes {
+		if p.match(f.segs, false) {
+			return selectAll
+		}
+		if p.match(f.segs, true) {
+			sel = selectSome
+		}
+	}
+	return sel
+}
+
+// flattenChild flattens the value at f.segs, whose container is at
+// path parent, unless it's not selected. Values that aren't selected
+// are skipped, without even building their paths.
+func (f *flattener) flattenChild(parent string) (errored bool) {
+	saved := f.sel
+	if f.sel = f.selectValue(); f.sel == selectNone {
+		if f.copyValue(discard{}) {
+			return true
+		}
+	} else if f.extract && len(f.segs) == len(f.target) {
+		// This is the value to extract.
+		path := f.path(parent)
+		if f.relative {
+			path = f.style.root()
+		}
+		if f.flattenValue(path) {
+			return true
+		}
+		if !f.many {
+			f.stop()
+			return true
+		}
+	} else if f.flattenValue(f.path(parent)) {
+		return true
+	}
+	f.sel = saved
+	return false
+}
+
+// path returns the path of the value at f.segs, whose container is
+// at path parent.
+func (f *flattener) path(parent string) string {
+	if len(f.segs) == 0 {
+		return f.style.root()
+	}
+	seg := f.segs[len(f.segs)-1]
+	if seg.key == "" {
+		if f.wildcards && f.wildcardArray(f.segs[:len(f.segs)-1]) {
+			return f.style.wildcard(parent, f.placeholder)
+		}
+		return f.style.index(parent, seg.index)
+	}
+	return f.style.key(parent, seg.key)
+}
+
 func (f *flattener) flattenValue(path string) (errored bool) {
+	if f.stopped {
+		return true
+	}
+	if f.canonical {
+		typ := f.nextItem().typ
+		f.backup()
+		f.compact.Reset()
+		if f.canonicalValue(&f.compact) {
+			return true
+		}
+		f.emit(path, f.compact.String(), typ)
+		return false
+	}
 	switch it := f.nextItem(); it.typ {
 	case itemError:
 		return f.errorf("flattenValue: lexer error: %v", it.val)
+	case itemLeftCurlyBrace, itemLeftBracket:
+		f.backup()
+		if len(f.segs) == f.maxDepth {
+			f.compact.Reset()
+			if f.copyValue(&f.compact) {
+				return true
+			}
+			f.emit(path, f.compact.String(), it.typ)
+			return false
+		}
+		if it.typ == itemLeftCurlyBrace {
+			return f.flattenObject(path)
+		}
+		return f.flattenArray(path)
+	case itemNumber:
+		f.emit(path, f.number(it.val), it.typ)
+		return false
+	case itemQuotedString, itemTrue, itemFalse, itemNull:
+		f.emit(path, it.val, it.typ)
+		return false
+	default:
+		return f.errorf("flattenValue: unexpected lexeme: %v", it)
+	}
+}
+
+// number returns the number lexeme s as it's to be passed to the
+// callback, see normalizeNumbers.
+func (f *flattener) number(s string) string {
+	if !f.normalize {
+		return s
+	}
+	return normalizeNumber(s, f.keepIntegers)
+}
+
+// sink is where copyValue writes values.
+type sink interface {
+	WriteByte(c byte) error
+	WriteString(s string) (int, error)
+}
+
+// discard is a sink that throws values away, for skipping them.
+type discard struct{}
+
+func (discard) WriteByte(byte) error {
+	return nil
+}
+
+func (discard) WriteString(s string) (int, error) {
+	return len(s), nil
+}
+
+// copyValue is like flattenValue, but instead of flattening the
+// value, it writes it to b, minified.
+func (f *flattener) copyValue(b sink) (errored bool) {
+	switch it := f.nextItem(); it.typ {
+	case itemError:
+		return f.errorf("copyValue: lexer error: %v", it.val)
 	case itemLeftCurlyBrace:
+		b.WriteByte('{')
+		if f.nextItem().typ == itemRightCurlyBrace {
+			b.WriteByte('}')
+			return false
+		}
 		f.backup()
-		f.cb(path, "{}", nil)
-		return f.flattenObject(path)
+		for {
+			it := f.nextItem()
+			if it.typ != itemQuotedString {
+				return f.errorf("copyValue: expected quoted string for key, got: %v", it)
+			}
+			b.WriteString(it.val)
+			if it := f.nextItem(); it.typ != itemColon {
+				return f.errorf("copyValue: expected colon after key, got: %v", it)
+			}
+			b.WriteByte(':')
+			if f.copyValue(b) {
+				return true
+			}
+			it = f.nextItem()
+			if it.typ == itemRightCurlyBrace {
+				b.WriteByte('}')
+				return false
+			}
+			if it.typ != itemComma {
+				return f.errorf("copyValue: expected comma or right curly brace after key-value pair, got: %v", it)
+			}
+			b.WriteByte(',')
+		}
 	case itemLeftBracket:
+		b.WriteByte('[')
+		if f.nextItem().typ == itemRightBracket {
+			b.WriteByte(']')
+			return false
+		}
 		f.backup()
-		f.cb(path, "[]", nil)
-		return f.flattenArray(path)
-	case itemQuotedString, itemUnquotedString:
-		f.cb(path, it.val, nil)
+		for {
+			if f.copyValue(b) {
+				return true
+			}
+			it := f.nextItem()
+			if it.typ == itemRightBracket {
+				b.WriteByte(']')
+				return false
+			}
+			if it.typ != itemComma {
+				return f.errorf("copyValue: expected comma or right bracket after value, got: %v", it)
+			}
+			b.WriteByte(',')
+		}
+	case itemNumber:
+		b.WriteString(f.number(it.val))
+		return false
+	case itemQuotedString, itemTrue, itemFalse, itemNull:
+		b.WriteString(it.val)
 		return false
 	default:
-		return f.errorf("flattenValue: unexpected lexeme: %v", it)
+		return f.errorf("copyValue: unexpected lexeme: %v", it)
+	}
+}
+
+// container emits the pair for an object or an array, whose value is
+// {} or [], unless only leaves are wanted and the container isn't
+// empty. When flattening many values, root containers are always
+// emitted, as they tell where each value starts.
+func (f *flattener) container(path string, value string, typ itemType, empty bool) {
+	if f.leaves && !empty && (len(f.segs) > 0 || !f.many) {
+		return
+	}
+	f.emit(path, value, typ)
+}
+
+// closing emits the pair for the end of an object or an array, if
+// sizes are wanted. Its value is } or ], followed by the number of
+// keys or elements, e.g., ]3.
+func (f *flattener) closing(path string, end item, size int) {
+	if f.sizes {
+		f.emit(path, end.val+strconv.Itoa(size), end.typ)
 	}
 }
 
 func (f *flattener) flattenObject(path string) (errored bool) {
-	f.nextItem()
-	if f.nextItem().typ == itemRightCurlyBrace {
+	open := f.nextItem()
+	if it := f.nextItem(); it.typ == itemRightCurlyBrace {
+		f.container(path, "{}", open.typ, true)
+		f.closing(path, it, 0)
 		return false
 	}
 	f.backup()
-	for {
+	f.container(path, "{}", open.typ, false)
+	var occurrences map[string]int
+	for size := 1; ; size++ {
 		it := f.nextItem()
 		if it.typ != itemQuotedString {
 			return f.errorf("flattenObject: expected quoted string for key, got: %v", it)
@@ -156,18 +834,24 @@ func (f *flattener) flattenObject(path string) (errored bool) {
 		if it := f.nextItem(); it.typ != itemColon {
 			return f.errorf("flattenObject: expected colon after key, got: %v", it)
 		}
-		var child string
-		if path == "." {
-			child = path + it.val
-		} else {
-			child = fmt.Sprintf("%s.%s", path, it.val)
+		seg := segment{key: it.val}
+		if f.keyNumbers {
+			if occurrences == nil {
+				occurrences = make(map[string]int)
+			}
+			key := unquote(it.val)
+			seg.index = occurrences[key]
+			occurrences[key]++
 		}
-		if f.flattenValue(child) {
+		f.push(seg)
+		if f.flattenChild(path) {
 			return true
 		}
+		f.pop()
 		// Either the object is complete, or there's a comma and another key-value pair.
 		it = f.nextItem()
 		if it.typ == itemRightCurlyBrace {
+			f.closing(path, it, size)
 			return false
 		}
 		if it.typ != itemComma {
@@ -177,18 +861,24 @@ func (f *flattener) flattenObject(path string) (errored bool) {
 }
 
 func (f *flattener) flattenArray(path string) (errored bool) {
-	f.nextItem()
-	if f.nextItem().typ == itemRightBracket {
+	open := f.nextItem()
+	if it := f.nextItem(); it.typ == itemRightBracket {
+		f.container(path, "[]", open.typ, true)
+		f.closing(path, it, 0)
 		return false
 	}
 	f.backup()
+	f.container(path, "[]", open.typ, false)
 	for index := 0; ; index++ {
-		if f.flattenValue(fmt.Sprintf("%s[%d]", path, index)) {
+		f.push(segment{index: index})
+		if f.flattenChild(path) {
 			return true
 		}
+		f.pop()
 		// Either the array is complete, or there's a comma and another value.
 		it := f.nextItem()
 		if it.typ == itemRightBracket {
+			f.closing(path, it, index+1)
 			return false
 		}
 		if it.typ != itemComma {
@@ -50,6 +50,55 @@ func (tt *flattenerTest) run(t *testing.T, f *flattener) {
 	}
 }
 
+// flattenLines runs f and returns a line for each pair, as made by
+// line, failing the test on errors.
+func flattenLines(t *testing.T, f *flattener, line func(p pair) string) []string {
+	t.Helper()
+	var lines []string
+	for _, p := range f.collect() {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		lines = append(lines, line(p))
+	}
+	return lines
+}
+
+// writeAll flattens input and writes the pairs with pw, failing the
+// test on any error.
+func writeAll(t *testing.T, pw pairWriter, input string, opts ...option) {
+	t.Helper()
+	for _, p := range newFlattener(strings.NewReader(input), opts...).collect() {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		if err := pw.writePair(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func pathAndValue(p pair) string {
+	return p.path + "\t" + p.value
+}
+
+func pathOnly(p pair) string {
+	return p.path
+}
+
+func mustParsePatterns(t *testing.T, ss []string) []pattern {
+	t.Helper()
+	var patterns []pattern
+	for _, s := range ss {
+		p, err := parsePattern(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
 // The main testing is via conformance checking in this file. Here
 // we'll only add a few basic tests and more tests for specific
 // regressions as they are encountered.
@@ -68,10 +117,23 @@ func TestFlattenerRegressions(t *testing.T) {
 					{path: ".", value: "[]"},
 				},
 			},
+			{
+				input: "null",
+				output: []pair{
+					{path: ".", value: "null"},
+				},
+			},
 			{
 				input: "unquoted",
 				output: []pair{
-					{path: ".", value: "unquoted"},
+					{err: errors.New(`line 1, column 1 (offset 0): flattenValue: lexer error: bad literal "unquoted": expected true, false, or null`)},
+				},
+			},
+			{
+				input: `{"a": 01.}`,
+				output: []pair{
+					{path: ".", value: "{}"},
+					{err: errors.New(`line 1, column 8 (offset 7): flattenValue: lexer error: bad number "0": unexpected '1'`)},
 				},
 			},
 			{
@@ -93,7 +155,7 @@ func TestFlattenerRegressions(t *testing.T) {
 				input: `1 2`,
 				output: []pair{
 					{path: ".", value: "1"},
-					{err: errors.New("expected to flatten one value and get EOF, got: 2")},
+					{err: errors.New("line 1, column 3 (offset 2): expected to flatten one value and get EOF, got: 2")},
 				},
 			},
 		}
@@ -278,38 +340,49 @@ func (jsonValue) Generate(rand *rand.Rand, size int) reflect.Value {
 }
 
 func TestFlattenerConformance(t *testing.T) {
-	f := func(input jsonValue) bool {
-		flattener := newFlattener(strings.NewReader(string(input)))
-		var pairs []string
-		for _, p := range flattener.collect() {
-			if p.err == io.EOF {
-				break
-			}
-			if p.err != nil {
-				t.Fatal(p.err)
-				return false
+	tests := []struct {
+		name string
+		opts []option
+	}{
+		{name: "default"},
+		{name: "validateStrings", opts: []option{validateStrings}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := func(input jsonValue) bool {
+				flattener := newFlattener(strings.NewReader(string(input)), tt.opts...)
+				var pairs []string
+				for _, p := range flattener.collect() {
+					if p.err == io.EOF {
+						break
+					}
+					if p.err != nil {
+						t.Fatal(p.err)
+						return false
+					}
+					pairs = append(pairs, fmt.Sprintf("%s\t%s", p.path, p.value))
+				}
+				sort.Strings(pairs)
+				var referencePairs []string
+				for _, pair := range stdlibFlatten([]byte(input)) {
+					path, value, err := pair.path, pair.value, pair.err
+					if err != nil {
+						t.Fatal(err)
+						return false
+					}
+					referencePairs = append(referencePairs, fmt.Sprintf("%s\t%s", path, value))
+				}
+				sort.Strings(referencePairs)
+				if diff := cmp.Diff(referencePairs, pairs); diff != "" {
+					t.Log(diff)
+					return false
+				}
+				return true
 			}
-			pairs = append(pairs, fmt.Sprintf("%s\t%s", p.path, p.value))
-		}
-		sort.Strings(pairs)
-		var referencePairs []string
-		for _, pair := range stdlibFlatten([]byte(input)) {
-			path, value, err := pair.path, pair.value, pair.err
-			if err != nil {
-				t.Fatal(err)
-				return false
+			if err := quick.Check(f, nil); err != nil {
+				t.Error(err)
 			}
-			referencePairs = append(referencePairs, fmt.Sprintf("%s\t%s", path, value))
-		}
-		sort.Strings(referencePairs)
-		if diff := cmp.Diff(referencePairs, pairs); diff != "" {
-			t.Log(diff)
-			return false
-		}
-		return true
-	}
-	if err := quick.Check(f, nil); err != nil {
-		t.Error(err)
+		})
 	}
 }
 
@@ -339,9 +412,9 @@ const sampleValue = `{
 func BenchmarkFlattener(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		f := newFlattener(strings.NewReader(sampleValue))
-		f.run(func(_ string, _ string, err error) {
-			if err != nil {
-				b.Fatal(err)
+		f.run(func(p pair) {
+			if p.err != nil {
+				b.Fatal(p.err)
 			}
 		})
 	}
@@ -352,3 +425,399 @@ func BenchmarkReferenceFlattener(b *testing.B) {
 		stdlibFlatten([]byte(sampleValue))
 	}
 }
+
+func TestFlattenerSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		pos     position
+		context string
+	}{
+		{
+			input:   `{"a": tru}`,
+			pos:     position{offset: 6, line: 1, column: 7},
+			context: "{\"a\": tru}\n      ^",
+		},
+		{
+			input:   "{\n\t\"a\": 1,\n\t\"b\" 2\n}",
+			pos:     position{offset: 16, line: 3, column: 6},
+			context: "\t\"b\" 2\n\t    ^",
+		},
+		{
+			input:   "[\"é\", \"\\q\"]",
+			pos:     position{offset: 8, line: 1, column: 8},
+			context: "[\"é\", \"\\q\"]\n       ^",
+		},
+		{
+			input:   "[1,\n2",
+			pos:     position{offset: 5, line: 2, column: 2},
+			context: "2\n ^",
+		},
+		{
+			// Only the tail of long lines is shown.
+			input:   "[1,\n" + strings.Repeat(" ", 200) + "x",
+			pos:     position{offset: 204, line: 2, column: 201},
+			context: strings.Repeat(" ", 127) + "x\n" + strings.Repeat(" ", 127) + "^",
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			var err error
+			newFlattener(strings.NewReader(tt.input), validateStrings).run(func(p pair) {
+				if p.err != nil && err == nil {
+					err = p.err
+				}
+			})
+			se, ok := err.(*syntaxError)
+			if !ok {
+				t.Fatalf("got %v, want a syntax error", err)
+			}
+			if se.pos != tt.pos {
+				t.Errorf("got %v, want %v", se.pos, tt.pos)
+			}
+			if got := se.context(); got != tt.context {
+				t.Errorf("got %q, want %q", got, tt.context)
+			}
+		})
+	}
+}
+
+func TestFlattenerRecovery(t *testing.T) {
+	tests := []struct {
+		flattenerTest
+		recovery  option
+		good, bad int
+	}{
+		{
+			recovery: lineRecords,
+			flattenerTest: flattenerTest{
+				input: `{"a":1}
+{"a": tru, "b": 2}
+{"a":3
+{"a":4}
+[5,]
+{"a":[1,
+{"b":
+7
+`,
+				output: []pair{
+					{path: ".", value: "{}"},
+					{path: `."a"`, value: "1"},
+					// The pairs of malformed values are left out.
+					{err: errors.New(`line 2, column 7 (offset 14): skipping value starting at line 2: flattenValue: lexer error: bad literal "tru": expected true, false, or null`)},
+					// Values end with their lines, so truncated values don't
+					// swallow the values that follow.
+					{err: errors.New(`line 3, column 7 (offset 33): skipping value starting at line 3: expected value to end on its line`)},
+					{path: ".", value: "{}"},
+					{path: `."a"`, value: "4"},
+					{err: errors.New(`line 5, column 4 (offset 45): skipping value starting at line 5: flattenValue: unexpected lexeme: "]"`)},
+					{err: errors.New(`line 6, column 9 (offset 55): skipping value starting at line 6: expected value to end on its line`)},
+					{err: errors.New(`line 7, column 6 (offset 61): skipping value starting at line 7: expected value to end on its line`)},
+					{path: ".", value: "7"},
+				},
+			},
+			good: 3,
+			bad:  5,
+		},
+		{
+			recovery: lineRecords,
+			// Values on many lines are malformed values, but the
+			// lines continuing them aren't values of their own.
+			flattenerTest: flattenerTest{
+				input: `{
+ "a": [
+  1,
+  {"b": 2}
+ ]
+}
+{"c": 3} "d": 4
+[
+ [1],
+ [2]
+]
+`,
+				output: []pair{
+					{err: errors.New(`line 1, column 2 (offset 1): skipping value starting at line 1: expected value to end on its line`)},
+					{path: ".", value: "{}"},
+					{path: `."c"`, value: "3"},
+					{err: errors.New(`line 7, column 13 (offset 43): skipping value starting at line 7: expected end of line or another value after value, got: ":"`)},
+					{err: errors.New(`line 8, column 2 (offset 48): skipping value starting at line 8: expected value to end on its line`)},
+				},
+			},
+			good: 1,
+			bad:  3,
+		},
+		{
+			// Without records on lines, values can span them, and
+			// flattening resumes at the next top-level value.
+			recovery: recoverErrors,
+			flattenerTest: flattenerTest{
+				input: `{
+ "a": tru,
+ "b": [1, 2]
+}
+{
+ "c": 3
+}
+[4,]
+5
+`,
+				output: []pair{
+					{err: errors.New(`line 2, column 7 (offset 8): skipping value starting at line 1: flattenValue: lexer error: bad literal "tru": expected true, false, or null`)},
+					{path: ".", value: "{}"},
+					{path: `."c"`, value: "3"},
+					{err: errors.New(`line 8, column 4 (offset 43): skipping value starting at line 8: flattenValue: unexpected lexeme: "]"`)},
+					{path: ".", value: "5"},
+				},
+			},
+			good: 2,
+			bad:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			f := newFlattener(strings.NewReader(tt.input), tt.recovery)
+			tt.run(t, f)
+			if good, bad := f.stats(); good != tt.good || bad != tt.bad {
+				t.Errorf("got %d good and %d bad values, want %d and %d", good, bad, tt.good, tt.bad)
+			}
+		})
+	}
+}
+
+func TestFlattenerStop(t *testing.T) {
+	f := newFlattener(strings.NewReader(`[1, 2, 3] [4]`), acceptMany)
+	var values []string
+	f.run(func(p pair) {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		values = append(values, p.value)
+		if p.value == "2" {
+			f.stop()
+		}
+	})
+	if got, want := strings.Join(values, " "), "[] 1 2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFlattenerTypes(t *testing.T) {
+	f := newFlattener(strings.NewReader(`{"a": [1, "1", null, true, false, {}]}`))
+	var types []string
+	for _, p := range f.collect() {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		types = append(types, jsonType(p.typ))
+	}
+	want := []string{"object", "array", "number", "string", "null", "boolean", "boolean", "object"}
+	if diff := cmp.Diff(want, types); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFlattenerMaxDepth(t *testing.T) {
+	input := `{"items": [{"login": "x", "id": 1, "t": [1, {"a" : null}]}, []], "n": 3}`
+	tests := []struct {
+		depth  int
+		output []string
+	}{
+		{
+			depth:  0,
+			output: []string{`.	{"items":[{"login":"x","id":1,"t":[1,{"a":null}]},[]],"n":3}`},
+		},
+		{
+			depth: 2,
+			output: []string{
+				".\t{}",
+				".\"items\"\t[]",
+				`."items"[0]	{"login":"x","id":1,"t":[1,{"a":null}]}`,
+				".\"items\"[1]\t[]",
+				".\"n\"\t3",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			output := flattenLines(t, newFlattener(strings.NewReader(input), maxDepth(tt.depth)), pathAndValue)
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+	// Syntax errors inside compacted values are still reported.
+	output := newFlattener(strings.NewReader(`{"a": {"b": 1 2}}`), maxDepth(1)).collect()
+	want := `line 1, column 15 (offset 14): copyValue: expected comma or right curly brace after key-value pair, got: "2"`
+	if last := output[len(output)-1]; last.err == nil || last.err.Error() != want {
+		t.Errorf("got %v, want %q", last.err, want)
+	}
+}
+
+func TestFlattenerLeavesOnly(t *testing.T) {
+	tests := []struct {
+		input  string
+		opts   []option
+		output []string
+	}{
+		{
+			input:  `{"a": [1, {"b": []}], "c": {}}`,
+			output: []string{".\"a\"[0]\t1", ".\"a\"[1].\"b\"\t[]", ".\"c\"\t{}"},
+		},
+		{
+			input:  `{}`,
+			output: []string{".\t{}"},
+		},
+		{
+			// Root containers tell where each value starts.
+			input:  `{"a": 1} {"a": 2}`,
+			opts:   []option{acceptMany},
+			output: []string{".\t{}", ".\"a\"\t1", ".\t{}", ".\"a\"\t2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			output := flattenLines(t, newFlattener(strings.NewReader(tt.input), append(tt.opts, leavesOnly)...), pathAndValue)
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestFlattenerSizes(t *testing.T) {
+	f := newFlattener(strings.NewReader(`{"items": [1, {"a": {}}, []], "n": 3}`), withSizes)
+	output := flattenLines(t, f, func(p pair) string {
+		return pathAndValue(p) + "\t" + jsonType(p.typ)
+	})
+	want := []string{
+		".\t{}\tobject",
+		".\"items\"\t[]\tarray",
+		".\"items\"[0]\t1\tnumber",
+		".\"items\"[1]\t{}\tobject",
+		".\"items\"[1].\"a\"\t{}\tobject",
+		".\"items\"[1].\"a\"\t}0\tobject",
+		".\"items\"[1]\t}1\tobject",
+		".\"items\"[2]\t[]\tarray",
+		".\"items\"[2]\t]0\tarray",
+		".\"items\"\t]3\tarray",
+		".\"n\"\t3\tnumber",
+		".\t}2\tobject",
+	}
+	if diff := cmp.Diff(want, output); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFlattenerPatterns(t *testing.T) {
+	input := `{"items": [{"login": "a", "url": "u1", "x": {"url": "u2"}}, {"login": "b"}, {"login": "c"}], "url": "top"}`
+	tests := []struct {
+		includes []string
+		excludes []string
+		output   []string
+	}{
+		{
+			includes: []string{`."items"[*]."login"`},
+			output:   []string{`."items"[0]."login"`, `."items"[1]."login"`, `."items"[2]."login"`},
+		},
+		{
+			includes: []string{`**."url"`},
+			output:   []string{`."items"[0]."url"`, `."items"[0]."x"."url"`, `."url"`},
+		},
+		{
+			includes: []string{`."items"[1:]`, `."url"`},
+			output:   []string{`."items"[1]`, `."items"[1]."login"`, `."items"[2]`, `."items"[2]."login"`, `."url"`},
+		},
+		{
+			excludes: []string{`."items"`},
+			output:   []string{`.`, `."url"`},
+		},
+		{
+			includes: []string{`."items"[0]`},
+			excludes: []string{`**."url"`},
+			output:   []string{`."items"[0]`, `."items"[0]."login"`, `."items"[0]."x"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			var opts []option
+			for _, p := range mustParsePatterns(t, tt.includes) {
+				opts = append(opts, include(p))
+			}
+			for _, p := range mustParsePatterns(t, tt.excludes) {
+				opts = append(opts, exclude(p))
+			}
+			output := flattenLines(t, newFlattener(strings.NewReader(input), opts...), pathOnly)
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+	// Skipped values are still checked for syntax errors.
+	p, _ := parsePattern(`."a"`)
+	output := newFlattener(strings.NewReader(`{"a": [1 2], "b": 3}`), exclude(p)).collect()
+	if last := output[len(output)-1]; last.err == nil {
+		t.Errorf("got %v, want an error", last)
+	}
+}
+
+func TestFlattenerExtract(t *testing.T) {
+	// The input after the extracted value isn't read, malformed as it is.
+	input := `{"items": [{"login": "a"}, {"login": "b", "x": [1]}, {"login": "c"}], "url": tru`
+	tests := []struct {
+		path     string
+		relative bool
+		output   []string
+	}{
+		{
+			path:   `."items"[1]`,
+			output: []string{`."items"[1]	{}`, `."items"[1]."login"	"b"`, `."items"[1]."x"	[]`, `."items"[1]."x"[0]	1`},
+		},
+		{
+			path:     `."items"[1]`,
+			relative: true,
+			output:   []string{`.	{}`, `."login"	"b"`, `."x"	[]`, `."x"[0]	1`},
+		},
+		{
+			path:     `."items"[0]."login"`,
+			relative: true,
+			output:   []string{`.	"a"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			target, err := parsePath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := flattenLines(t, newFlattener(strings.NewReader(input), extract(target, tt.relative)), pathAndValue)
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestFlattenerWildcards(t *testing.T) {
+	input := `{"a": [{"t": [1]}], "b": [[2]]}`
+	tests := []struct {
+		patterns []string
+		output   []string
+	}{
+		{
+			output: []string{`.`, `."a"`, `."a"[*]`, `."a"[*]."t"`, `."a"[*]."t"[*]`, `."b"`, `."b"[*]`, `."b"[*][*]`},
+		},
+		{
+			patterns: []string{`."a"`, `."b"[*]`},
+			output:   []string{`.`, `."a"`, `."a"[*]`, `."a"[*]."t"`, `."a"[*]."t"[0]`, `."b"`, `."b"[0]`, `."b"[0][*]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			f := newFlattener(strings.NewReader(input), withWildcards("*", mustParsePatterns(t, tt.patterns)...))
+			output := flattenLines(t, f, pathOnly)
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 )
 
 type itemType int
@@ -22,19 +23,56 @@ const (
 	itemLeftCurlyBrace
 	itemRightCurlyBrace
 
-	// For the purpose of flattening JSON documents, we don't have to be
-	// precise in how we break up primitive values. The unquoted string
-	// item type will apply to numbers, true, false, null, and also
-	// illegal strings.
 	itemQuotedString
-	itemUnquotedString
+	itemNumber
+	itemTrue
+	itemFalse
+	itemNull
 )
 
+// JSON types, as named by jsonType.
+var jsonTypes = []string{"object", "array", "string", "number", "boolean", "null"}
+
+// jsonType returns the name of the type of JSON values that start
+// or end with an item of type t, or the empty string if values can't
+// start or end with such an item.
+func jsonType(t itemType) string {
+	switch t {
+	case itemLeftCurlyBrace, itemRightCurlyBrace:
+		return "object"
+	case itemLeftBracket, itemRightBracket:
+		return "array"
+	case itemQuotedString:
+		return "string"
+	case itemNumber:
+		return "number"
+	case itemTrue, itemFalse:
+		return "boolean"
+	case itemNull:
+		return "null"
+	default:
+		return ""
+	}
+}
+
 const eof rune = -1
 
+// position is a position in the input.
+type position struct {
+	offset int64 // In bytes, starting from 0.
+	line   int   // Starting from 1.
+	column int   // In runes, starting from 1.
+}
+
+// String implements fmt.Stringer.
+func (p position) String() string {
+	return fmt.Sprintf("line %d, column %d (offset %d)", p.line, p.column, p.offset)
+}
+
 type item struct {
 	typ itemType
 	val string
+	pos position // Where the item starts, or where the error is, for errors.
 }
 
 // String implements fmt.Stringer.
@@ -65,6 +103,22 @@ type lexer struct {
 	width  int // The width of last rune read from input and written to the buffer.
 	items  chan item
 	state  stateFn
+
+	start position // Start of the item being lexed.
+	pos   position // Position of the next rune to read from input.
+	prev  position // Position before the last rune read, for backup.
+
+	// The input around the current line, copied from that buffered
+	// by input a chunk at a time, to show errors in context, see
+	// excerpt and record.
+	window    []byte
+	winStart  int64 // Offset of window[0].
+	lineStart int64 // Offset of the current line.
+	lineEnded bool  // Whether the last rune read was a newline.
+
+	// Whether to validate quoted strings, see lexQuotedString.
+	validate bool
+	inString bool // Whether the last error is in a quoted string.
 }
 
 func newLexer(r io.Reader) *lexer {
@@ -76,6 +130,8 @@ func newLexer(r io.Reader) *lexer {
 		input: bio,
 		items: make(chan item, 1),
 		state: lexWhitespace,
+		start: position{line: 1, column: 1},
+		pos:   position{line: 1, column: 1},
 	}
 	return l
 }
@@ -87,7 +143,7 @@ func (l *lexer) nextItem() item {
 			return it
 		default:
 			if l.state == nil {
-				l.items <- item{typ: itemEOF}
+				l.items <- item{typ: itemEOF, pos: l.pos}
 			} else {
 				l.state = l.state(l)
 			}
@@ -96,32 +152,89 @@ func (l *lexer) nextItem() item {
 }
 
 func (l *lexer) emit(t itemType) {
-	l.items <- item{t, l.buffer.String()}
+	l.items <- item{t, l.buffer.String(), l.start}
 	l.buffer.Reset()
+	l.start = l.pos
 }
 
+// maxLineBuf bounds the parts of long lines shown in excerpts: up to
+// twice as much before the position read, and as much after it.
+const maxLineBuf = 64
+
 func (l *lexer) next() (r rune) {
+	if l.lineEnded {
+		l.lineStart = l.pos.offset
+		l.lineEnded = false
+	}
+	if l.pos.offset+utf8.UTFMax > l.winStart+int64(len(l.window)) {
+		l.record()
+	}
 	var err error
 	r, l.width, err = l.input.ReadRune()
 	if err != nil {
 		l.width = 0
 		return eof
 	}
-	l.buffer.WriteRune(r)
+	if r == utf8.RuneError && l.width == 1 {
+		// Keep the invalid byte, not the replacement character.
+		l.buffer.WriteByte(l.window[l.pos.offset-l.winStart])
+	} else {
+		l.buffer.WriteRune(r)
+	}
+	l.prev = l.pos
+	l.pos.offset += int64(l.width)
+	if r == '\n' {
+		l.pos.line++
+		l.pos.column = 1
+		l.lineEnded = true
+	} else {
+		l.pos.column++
+	}
 	return r
 }
 
+// record copies the input that's buffered, but not yet in the window,
+// to the window, making sure the next rune is in it, and drops what's
+// before the current line, or too far back in it. Peeking invalidates
+// backup, so only call it before reading a rune.
+func (l *lexer) record() {
+	_, _ = l.input.Peek(utf8.UTFMax)
+	buffered, _ := l.input.Peek(l.input.Buffered())
+	keep := l.lineStart
+	if k := l.pos.offset - 2*maxLineBuf; k > keep {
+		keep = k
+	}
+	if keep < l.winStart {
+		keep = l.winStart
+	}
+	n := int(keep - l.winStart)
+	for n < len(l.window) && !utf8.RuneStart(l.window[n]) {
+		n++
+	}
+	seen := l.winStart + int64(len(l.window))
+	l.window = append(l.window[:0], l.window[n:]...)
+	l.winStart += int64(n)
+	l.window = append(l.window, buffered[seen-l.pos.offset:]...)
+}
+
 func (l *lexer) ignore() {
 	l.buffer.Reset()
+	l.start = l.pos
 }
 
 // Can be called only once per call of next.
 func (l *lexer) backup() {
 	if l.width > 0 {
-		// An error would be returned if ReadRune wasn't the previous
-		// operation on l.input.
-		_ = l.input.UnreadRune()
+		// An error would be returned if ReadRune (or ReadByte)
+		// wasn't the previous operation on l.input.
+		if l.width == 1 {
+			_ = l.input.UnreadByte()
+		} else {
+			_ = l.input.UnreadRune()
+		}
 		l.buffer.Truncate(l.buffer.Len() - l.width)
+		l.lineEnded = false
+		l.pos = l.prev
 	}
 }
 
@@ -145,14 +258,93 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
+// errorf emits an error item at the position of the next rune to
+// read, and stops the lexer.
 func (l *lexer) errorf(format string, a ...interface{}) stateFn {
+	return l.errorAt(l.pos, format, a...)
+}
+
+func (l *lexer) errorAt(p position, format string, a ...interface{}) stateFn {
 	l.items <- item{
 		itemError,
 		fmt.Sprintf(format, a...),
+		p,
 	}
 	return nil
 }
 
+// resume restarts lexing after an error, right after it, or after
+// the quoted string it's in. Used to recover from errors.
+func (l *lexer) resume() {
+	if l.inString {
+		for r := l.next(); r != '"' && r != '\n' && r != eof; r = l.next() {
+			if r == '\\' {
+				l.next()
+			}
+		}
+		l.inString = false
+	}
+	l.buffer.Reset()
+	l.start = l.pos
+	l.state = lexWhitespace
+}
+
+// restOfLineBlank skips blanks up to and including the end of the
+// line, and tells whether there's nothing else on it, in which case
+// the line is consumed. Only call it between items.
+func (l *lexer) restOfLineBlank() bool {
+	if l.state == nil {
+		return false
+	}
+	for {
+		switch l.next() {
+		case ' ', '\t', '\r':
+		case '\n', eof:
+			l.ignore()
+			return true
+		default:
+			l.backup()
+			l.ignore()
+			return false
+		}
+	}
+}
+
+// excerpt returns the part of the current line around p, and the
+// index in it of the byte at p. It fails if p is not on the current
+// line, or too far back in it.
+func (l *lexer) excerpt(p position) (text string, index int, ok bool) {
+	start, end := l.lineStart, l.pos.offset
+	if l.lineEnded {
+		end--
+	}
+	if end-start > 2*maxLineBuf {
+		start = end - 2*maxLineBuf
+	}
+	if start < l.winStart {
+		start = l.winStart
+	}
+	before := l.window[start-l.winStart : end-l.winStart]
+	for len(before) > 0 && !utf8.RuneStart(before[0]) {
+		before = before[1:]
+		start++
+	}
+	index = int(p.offset - start)
+	if index < 0 || index > len(before) {
+		return "", 0, false
+	}
+	// Peeking invalidates backup, but the lexer is between items
+	// here, and it won't back up before reading again.
+	after, _ := l.input.Peek(maxLineBuf)
+	if l.lineEnded {
+		after = nil
+	}
+	if i := bytes.IndexAny(after, "\r\n"); i != -1 {
+		after = after[:i]
+	}
+	return strings.TrimRight(string(before), "\r") + string(after), index, true
+}
+
 func lexWhitespace(l *lexer) stateFn {
 	l.acceptRun(" \t\r\n")
 	l.ignore()
@@ -174,8 +366,10 @@ func lexWhitespace(l *lexer) stateFn {
 		return lexRightBracket
 	case '"':
 		return lexQuotedString
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return lexNumber
 	default:
-		return lexUnquotedString
+		return lexLiteral
 	}
 }
 
@@ -215,30 +409,151 @@ func lexRightCurlyBrace(l *lexer) stateFn {
 	return lexWhitespace
 }
 
+// lexQuotedString lexes a quoted string. Only when validating does
+// it check escape sequences, control characters, and UTF-8 encoding,
+// otherwise it just looks for the closing quote.
 func lexQuotedString(l *lexer) stateFn {
 	l.accept(`"`)
 	for {
-		switch l.next() {
-		case '\\':
-			l.next()
-		case '"':
+		r := l.next()
+		switch {
+		case r == '"':
 			l.emit(itemQuotedString)
 			return lexWhitespace
-		case eof:
-			return l.errorf("unfinished quoted string")
+		case r == eof:
+			return l.errorAt(l.start, "unfinished quoted string")
+		case !l.validate:
+			if r == '\\' {
+				l.next()
+			}
+		case r == '\\':
+			at := l.prev
+			if err := l.acceptEscape(); err != nil {
+				l.inString = true
+				return l.errorAt(at, "%v", err)
+			}
+		case r < 0x20:
+			l.backup()
+			l.inString = true
+			return l.errorf("unescaped control character %U in quoted string", r)
+		case r == utf8.RuneError && l.width == 1:
+			l.backup()
+			l.inString = true
+			return l.errorf("invalid UTF-8 in quoted string")
 		}
 	}
 }
 
-// Any piece of garbage delimited by whitespace or special characters
-// is lexed here.
-func lexUnquotedString(l *lexer) stateFn {
-	for {
-		r := l.next()
-		if strings.ContainsRune(" \t\r\n{}[]:,\"", r) || r == eof {
-			l.backup()
-			l.emit(itemUnquotedString)
-			return lexWhitespace
+// acceptEscape accepts the rest of an escape sequence, the backslash
+// having been accepted already. A \u escape for a high surrogate must
+// be followed by one for a low surrogate, and vice versa.
+func (l *lexer) acceptEscape() error {
+	start := l.buffer.Len() - 1
+	escape := func() string {
+		return string(l.buffer.Bytes()[start:])
+	}
+	switch l.next() {
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		return nil
+	case 'u':
+		r, ok := l.acceptHex4()
+		if !ok {
+			return fmt.Errorf("invalid escape sequence %s: expected 4 hex digits", escape())
+		}
+		if r >= 0xdc00 && r <= 0xdfff {
+			return fmt.Errorf("unpaired surrogate in escape sequence %s", escape())
+		}
+		if r < 0xd800 || r > 0xdbff {
+			return nil
+		}
+		if !l.accept(`\`) || !l.accept("u") {
+			return fmt.Errorf("unpaired surrogate in escape sequence %s", escape())
+		}
+		r, ok = l.acceptHex4()
+		if !ok {
+			return fmt.Errorf("invalid escape sequence %s: expected 4 hex digits", escape())
+		}
+		if r < 0xdc00 || r > 0xdfff {
+			return fmt.Errorf("unpaired surrogate in escape sequence %s", escape())
+		}
+		return nil
+	case eof:
+		return fmt.Errorf("unfinished escape sequence")
+	default:
+		return fmt.Errorf("invalid escape sequence %s", escape())
+	}
+}
+
+// acceptHex4 accepts four hex digits and returns their value.
+func (l *lexer) acceptHex4() (r rune, ok bool) {
+	for i := 0; i < 4; i++ {
+		if !l.accept(hexDigits) {
+			return 0, false
+		}
+	}
+	return hex4(string(l.buffer.Bytes()[l.buffer.Len()-4:]))
+}
+
+const (
+	digits    = "0123456789"
+	hexDigits = "0123456789abcdefABCDEF"
+)
+
+// isDelimiter tells whether r can follow a number or a literal.
+func isDelimiter(r rune) bool {
+	return strings.ContainsRune(" \t\r\n{}[]:,\"", r) || r == eof
+}
+
+// lexNumber lexes a number according to the grammar in RFC 8259,
+// section 6:
+//
+//	number = [ minus ] int [ frac ] [ exp ]
+//	int = zero / ( digit1-9 *DIGIT )
+//	frac = decimal-point 1*DIGIT
+//	exp = e [ minus / plus ] 1*DIGIT
+func lexNumber(l *lexer) stateFn {
+	l.accept("-")
+	if !l.accept("0") {
+		if !l.accept("123456789") {
+			return l.errorf("bad number %q: expected digit", l.buffer.String())
+		}
+		l.acceptRun(digits)
+	}
+	if l.accept(".") {
+		if !l.accept(digits) {
+			return l.errorf("bad number %q: expected digit after decimal point", l.buffer.String())
+		}
+		l.acceptRun(digits)
+	}
+	if l.accept("eE") {
+		l.accept("+-")
+		if !l.accept(digits) {
+			return l.errorf("bad number %q: expected digit in exponent", l.buffer.String())
 		}
+		l.acceptRun(digits)
+	}
+	if r := l.peek(); !isDelimiter(r) {
+		return l.errorf("bad number %q: unexpected %q", l.buffer.String(), r)
+	}
+	l.emit(itemNumber)
+	return lexWhitespace
+}
+
+// lexLiteral lexes true, false, and null. Anything else that isn't
+// a number or a quoted string ends up here too, and is an error.
+func lexLiteral(l *lexer) stateFn {
+	for !isDelimiter(l.next()) {
+	}
+	l.backup()
+	switch l.buffer.String() {
+	case "true":
+		l.emit(itemTrue)
+	case "false":
+		l.emit(itemFalse)
+	case "null":
+		l.emit(itemNull)
+	default:
+		return l.errorAt(l.start, "bad literal %q: expected true, false, or null", l.buffer.String())
 	}
+	return lexWhitespace
 }
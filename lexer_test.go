@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/quick"
+	"unicode/utf8"
 )
 
 type lexerTest struct {
@@ -31,25 +36,81 @@ func TestLexerRegressions(t *testing.T) {
 				{typ: itemEOF},
 			},
 		},
+		{
+			input: "true false null",
+			output: []item{
+				{typ: itemTrue, val: "true"},
+				{typ: itemFalse, val: "false"},
+				{typ: itemNull, val: "null"},
+				{typ: itemEOF},
+			},
+		},
 		{
 			input: "unquoted",
 			output: []item{
-				{typ: itemUnquotedString, val: "unquoted"},
+				{typ: itemError, val: `bad literal "unquoted": expected true, false, or null`},
 				{typ: itemEOF},
 			},
 		},
 		{
-			input: "unquoted string",
+			input: "[tru]",
 			output: []item{
-				{typ: itemUnquotedString, val: "unquoted"},
-				{typ: itemUnquotedString, val: "string"},
+				{typ: itemLeftBracket, val: "["},
+				{typ: itemError, val: `bad literal "tru": expected true, false, or null`},
 				{typ: itemEOF},
 			},
 		},
 		{
 			input: "42",
 			output: []item{
-				{typ: itemUnquotedString, val: "42"},
+				{typ: itemNumber, val: "42"},
+				{typ: itemEOF},
+			},
+		},
+		{
+			input: "0 -0 -12.5 1e10 1E+2 0.5e-07",
+			output: []item{
+				{typ: itemNumber, val: "0"},
+				{typ: itemNumber, val: "-0"},
+				{typ: itemNumber, val: "-12.5"},
+				{typ: itemNumber, val: "1e10"},
+				{typ: itemNumber, val: "1E+2"},
+				{typ: itemNumber, val: "0.5e-07"},
+				{typ: itemEOF},
+			},
+		},
+		{
+			input: "01.",
+			output: []item{
+				{typ: itemError, val: `bad number "0": unexpected '1'`},
+				{typ: itemEOF},
+			},
+		},
+		{
+			input: "1.",
+			output: []item{
+				{typ: itemError, val: `bad number "1.": expected digit after decimal point`},
+				{typ: itemEOF},
+			},
+		},
+		{
+			input: "-",
+			output: []item{
+				{typ: itemError, val: `bad number "-": expected digit`},
+				{typ: itemEOF},
+			},
+		},
+		{
+			input: "1e+",
+			output: []item{
+				{typ: itemError, val: `bad number "1e+": expected digit in exponent`},
+				{typ: itemEOF},
+			},
+		},
+		{
+			input: "12abc",
+			output: []item{
+				{typ: itemError, val: `bad number "12": unexpected 'a'`},
 				{typ: itemEOF},
 			},
 		},
@@ -84,7 +145,7 @@ func TestLexerRegressions(t *testing.T) {
 				{typ: itemComma, val: ","},
 				{typ: itemQuotedString, val: `"age"`},
 				{typ: itemColon, val: ":"},
-				{typ: itemUnquotedString, val: "42"},
+				{typ: itemNumber, val: "42"},
 				{typ: itemRightCurlyBrace, val: "}"},
 				{typ: itemEOF},
 			},
@@ -93,17 +154,17 @@ func TestLexerRegressions(t *testing.T) {
 			input: `[ 1, 1, 2, 3, 5, 8 ]`,
 			output: []item{
 				{typ: itemLeftBracket, val: "["},
-				{typ: itemUnquotedString, val: "1"},
+				{typ: itemNumber, val: "1"},
 				{typ: itemComma, val: ","},
-				{typ: itemUnquotedString, val: "1"},
+				{typ: itemNumber, val: "1"},
 				{typ: itemComma, val: ","},
-				{typ: itemUnquotedString, val: "2"},
+				{typ: itemNumber, val: "2"},
 				{typ: itemComma, val: ","},
-				{typ: itemUnquotedString, val: "3"},
+				{typ: itemNumber, val: "3"},
 				{typ: itemComma, val: ","},
-				{typ: itemUnquotedString, val: "5"},
+				{typ: itemNumber, val: "5"},
 				{typ: itemComma, val: ","},
-				{typ: itemUnquotedString, val: "8"},
+				{typ: itemNumber, val: "8"},
 				{typ: itemRightBracket, val: "]"},
 				{typ: itemEOF},
 			},
@@ -124,3 +185,84 @@ func TestLexerRegressions(t *testing.T) {
 		})
 	}
 }
+
+func TestLexerStringValidation(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{input: `"plain"`},
+		{input: `"\" \\ \/ \b \f \n \r \t"`},
+		{input: `"é é é"`},
+		{input: `"😀"`},
+		{input: `"\q"`, err: `invalid escape sequence \q`},
+		{input: `"\u12"`, err: `invalid escape sequence \u12: expected 4 hex digits`},
+		{input: `"\u12x4"`, err: `invalid escape sequence \u12: expected 4 hex digits`},
+		{input: `"\ud83d"`, err: `unpaired surrogate in escape sequence \ud83d`},
+		{input: `"\ud83d\n"`, err: `unpaired surrogate in escape sequence \ud83d\`},
+		{input: `"\ud83dA"`, err: `unpaired surrogate in escape sequence \ud83d`},
+		{input: `"\ude00"`, err: `unpaired surrogate in escape sequence \ude00`},
+		{input: "\"tab\there\"", err: "unescaped control character U+0009 in quoted string"},
+		{input: "\"\xff\"", err: "invalid UTF-8 in quoted string"},
+		{input: `"\`, err: "unfinished escape sequence"},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			l := newLexer(strings.NewReader(tt.input))
+			l.validate = true
+			it := l.nextItem()
+			if tt.err == "" {
+				if it.typ != itemQuotedString || it.val != tt.input {
+					t.Errorf("got %v, want %q", it, tt.input)
+				}
+				return
+			}
+			if it.typ != itemError {
+				t.Fatalf("got %v, want error %q", it, tt.err)
+			}
+			if it.val != tt.err {
+				t.Errorf("got %q, want %q", it.val, tt.err)
+			}
+		})
+	}
+}
+
+// quotedString is a randomly generated quoted string, made up of
+// pieces that are likely to exercise string validation.
+type quotedString string
+
+// Generate implements testing/quick.Generator.
+func (quotedString) Generate(rand *rand.Rand, size int) reflect.Value {
+	pieces := []string{
+		"a", "z", "é", "\x01", "\t", "\xff", "\xc3",
+		`\`, `\\`, `\"`, `\/`, `\n`, `\q`, `\u`,
+		"0", "e", "00e9", "00", "\u2028", " ",
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for n := rand.Intn(8); n > 0; n-- {
+		b.WriteString(pieces[rand.Intn(len(pieces))])
+	}
+	b.WriteByte('"')
+	return reflect.ValueOf(quotedString(b.String()))
+}
+
+// The validating lexer must accept a quoted string if and only if
+// the standard library does. The standard library is lenient about
+// invalid UTF-8 and unpaired surrogates, though, so the generator
+// doesn't produce the latter and invalid UTF-8 is checked separately.
+func TestLexerStringValidationConformance(t *testing.T) {
+	f := func(input quotedString) bool {
+		l := newLexer(strings.NewReader(string(input)))
+		l.validate = true
+		accepted := l.nextItem().typ == itemQuotedString && l.nextItem().typ == itemEOF
+		if want := json.Valid([]byte(input)) && utf8.ValidString(string(input)); accepted != want {
+			t.Logf("got %t, want %t for %q", accepted, want, input)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+}
@@ -5,41 +5,373 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 )
 
 func main() {
+	os.Exit(jf())
+}
+
+// jf runs the command and returns its exit status. It's separate
+// from main so that deferred calls run before exiting.
+func jf() int {
+	// Have writes to a closed pipe fail with EPIPE, which the reporter
+	// expects, rather than kill the process before deferred calls run,
+	// e.g., those removing temporary files.
+	signal.Ignore(syscall.SIGPIPE)
+	if len(os.Args) > 1 && os.Args[1] == "sort" {
+		return jfSort(os.Args[2:])
+	}
 	many := flag.Bool("m", false, "decode many values")
 	unbuffered := flag.Bool("u", false, "unbuffered (print output line by line)")
+	policy := flag.String("e", policyFail, "error `policy`: "+policyFail+" (stop at the first malformed value) or "+policyContinue+" (skip malformed values, resuming at the next top-level value, and print a summary; implies -m)")
+	keepGoing := flag.Bool("k", false, "keep going through newline-delimited values (NDJSON): like -e "+policyContinue+", but values must end on their lines, and after an error, flattening resumes at the next line")
+	jsonErrors := flag.Bool("json-errors", false, "write errors to standard error as JSON objects, one per line")
+	strict := flag.Bool("strict", false, "validate escape sequences and UTF-8 encoding of strings")
+	pointers := flag.Bool("P", false, "print paths as JSON Pointers (RFC 6901), e.g., /fruit/0/name")
+	gron := flag.Bool("g", false, "print pairs as JavaScript assignments, as in gron, e.g., json.fruit[0].name = \"banana\";")
+	nul := flag.Bool("0", false, "print paths and values separated by NUL bytes, with keys and strings decoded")
+	asCSV := flag.Bool("csv", false, "print pairs as CSV records, with strings decoded")
+	columnList := flag.String("columns", "", "extra `columns` for -csv, comma-separated: "+strings.Join(csvColumns, ", "))
+	jsonLines := flag.Bool("j", false, "print each pair as a JSON object with path, pointer, type, and value, one per line")
+	align := flag.Bool("a", false, "align values in a column, like tab(1)")
+	window := flag.Int("window", 1000, "with -a, align this many `lines` at a time")
+	depth := flag.Int("d", -1, "print objects and arrays at `depth` whole, as minified JSON, rather than flattening them")
+	leaves := flag.Bool("l", false, "only print leaves, i.e., scalars and empty objects and arrays")
+	sizes := flag.Bool("c", false, "print a line at the end of each object and array, with the number of keys or elements, e.g., ]3")
+	var includes, excludes patterns
+	flag.Var(&includes, "i", "only print values whose paths match `pattern`, and their contents; can be repeated")
+	flag.Var(&excludes, "x", "don't print values whose paths match `pattern`, nor their contents; can be repeated")
+	extractPath := flag.String("p", "", "only print the value at `path`, and its contents, and stop reading as soon as that's done, unless -m is given")
+	relative := flag.Bool("relative", false, "with -p, print paths relative to the extracted value")
+	valueMatch := flag.String("value-match", "", "only print scalars whose values, decoded if strings, match `regexp`")
+	where := flag.String("where", "", "only print numbers for which `expr` holds, e.g., 'num > 100'")
+	ancestors := flag.Bool("ancestors", false, "with -value-match or -where, also print the objects and arrays containing the values printed")
+	wildcards := flag.Bool("w", false, "print * instead of array indices in paths, e.g., .\"fruit\"[*].\"name\"")
+	var wildcardArrays patterns
+	flag.Var(&wildcardArrays, "warrays", "only replace the indices of arrays whose paths match `pattern`; implies -w; can be repeated")
+	placeholder := flag.String("placeholder", "*", "with -w, print `string` instead of array indices")
+	sortPairs := flag.Bool("sort", false, "sort the pairs of each value by path, then by value, e.g., to compare values as multisets with -w")
+	sortKeys := flag.Bool("s", false, "print the keys of objects in sorted order")
+	sortMem := flag.Int("sortmem", 64<<20, "with -s, hold on to this many `bytes` of pairs at most, using temporary files for the rest")
+	jcs := flag.Bool("jcs", false, "print each value in canonical form (RFC 8785), one per line, rather than flattening it")
+	normalize := flag.Bool("n", false, "print numbers in canonical decimal form, e.g., 1 for 1.0, 1e0, and 10E-1")
+	keepIntegers := flag.Bool("keep-integers", false, "with -n, print integers as they are written")
+	showTypes := flag.Bool("T", false, "print the JSON type of each value in a third column")
+	typeList := flag.String("t", "", "only print values of the given comma-separated `types`: "+strings.Join(jsonTypes, ", "))
+	reverse := flag.Bool("r", false, "unflatten (read path-value pairs and print JSON values)")
+	colorMode := flag.String("color", "", "color the output: `mode` is "+colorAuto+" (if writing to a terminal), "+colorAlways+", or "+colorNever+" (default $JF_COLOR, or "+colorAuto+")")
 	flag.Parse()
+	if *keepGoing {
+		*policy = policyContinue
+	}
+	if *policy != policyFail && *policy != policyContinue {
+		return usageError("unknown error policy %q", *policy)
+	}
+	if *reverse && *policy == policyContinue {
+		// Unflattening stops at the first error.
+		return usageError("-r can't be used with -e %s or -k", policyContinue)
+	}
+	typeNames, err := parseList(*typeList, jsonTypes, "type")
+	if err != nil {
+		return usageError("%v", err)
+	}
+	columns, err := parseList(*columnList, csvColumns, "column")
+	if err != nil {
+		return usageError("%v", err)
+	}
+	if *showTypes && !contains(columns, "type") {
+		columns = append(columns, "type")
+	}
+	if countTrue(*gron, *nul, *asCSV, *jsonLines) > 1 {
+		return usageError("only one of -g, -0, -csv, and -j can be used")
+	}
+	if *align && countTrue(*gron, *nul, *asCSV, *jsonLines) > 0 {
+		return usageError("-a can't be used with -g, -0, -csv, or -j")
+	}
+	if *sizes && (*gron || *jsonLines) {
+		return usageError("-c can't be used with -g or -j")
+	}
+	if *extractPath != "" && len(includes) > 0 {
+		return usageError("-p can't be used with -i")
+	}
+	if *depth >= 0 && *sortKeys {
+		// The objects printed whole would keep their order.
+		return usageError("-d can't be used with -s")
+	}
+	if *depth >= 0 && len(includes)+len(excludes) > 0 {
+		// Patterns would only see the paths down to depth.
+		return usageError("-d can't be used with -i or -x")
+	}
+	if len(wildcardArrays) > 0 {
+		*wildcards = true
+	}
+	if *wildcards && *jsonLines {
+		return usageError("-w can't be used with -j")
+	}
+	if *jcs && countTrue(*gron, *nul, *asCSV, *jsonLines, *align, *showTypes, *leaves, *sizes, *wildcards, *sortPairs, *sortKeys, *extractPath != "", *depth >= 0, len(includes) > 0, len(excludes) > 0, *valueMatch != "", *where != "", typeNames != nil) > 0 {
+		return usageError("-jcs can only be used with -m, -u, -e, -k, and -json-errors")
+	}
+	if *gron && *pointers {
+		return usageError("-g can't be used with -P")
+	}
+	var vf *valueFilter
+	if *valueMatch != "" || *where != "" {
+		vf = &valueFilter{ancestors: *ancestors}
+		if *valueMatch != "" {
+			re, err := regexp.Compile(*valueMatch)
+			if err != nil {
+				return usageError("%v", err)
+			}
+			vf.preds = append(vf.preds, matchValue(re))
+		}
+		if *where != "" {
+			pred, err := parseWhere(*where)
+			if err != nil {
+				return usageError("%v", err)
+			}
+			vf.preds = append(vf.preds, pred)
+		}
+		// The types are a predicate too, so they don't apply to
+		// ancestors.
+		if typeNames != nil {
+			names := typeNames
+			vf.preds = append(vf.preds, func(p pair) bool {
+				return contains(names, jsonType(p.typ))
+			})
+			typeNames = nil
+		}
+	}
+	color, err := useColor(*colorMode, os.Stdout)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	// Colors are for people, not for the formats meant for programs.
+	color = color && !*nul && !*asCSV && !*jsonLines && !*jcs
+	rep := newReporter(os.Stderr, *jsonErrors)
 	var opts []option
 	if *many {
 		opts = append(opts, acceptMany)
 	}
-	var out io.Writer
-	if *unbuffered {
-		out = os.Stdout
+	if *keepGoing {
+		opts = append(opts, lineRecords)
+	} else if *policy == policyContinue {
+		opts = append(opts, recoverErrors)
+	}
+	if *strict {
+		opts = append(opts, validateStrings)
+	}
+	if *depth >= 0 {
+		opts = append(opts, maxDepth(*depth))
+	}
+	if *jcs {
+		opts = append(opts, canonicalize)
+	}
+	if *normalize {
+		opts = append(opts, normalizeNumbers(*keepIntegers))
+	}
+	if *leaves {
+		opts = append(opts, leavesOnly)
+	}
+	if *sortKeys {
+		// By the flattener, as -l, -t, and the value filters drop
+		// the pairs of objects.
+		opts = append(opts, numberKeys)
+	}
+	if *sizes {
+		opts = append(opts, withSizes)
+	}
+	if *extractPath != "" {
+		target, err := parsePath(*extractPath)
+		if err != nil {
+			return usageError("%v", err)
+		}
+		opts = append(opts, extract(target, *relative))
+	}
+	if *wildcards {
+		opts = append(opts, withWildcards(*placeholder, wildcardArrays...))
+	}
+	for _, p := range includes {
+		opts = append(opts, include(p))
+	}
+	for _, p := range excludes {
+		opts = append(opts, exclude(p))
+	}
+	var style pathStyle = jfStyle{}
+	switch {
+	case *pointers:
+		style = pointerStyle{}
+	case *gron:
+		style = gronStyle{}
+	case *nul:
+		style = decodedStyle{}
+	}
+	if color {
+		style = colorStyle{style}
+	}
+	opts = append(opts, withPathStyle(style))
+	var bio *bufio.Writer
+	out := io.Writer(os.Stdout)
+	if !*unbuffered {
+		bio = bufio.NewWriter(os.Stdout)
+		out = bio
+	}
+	var pw pairWriter = &tsvWriter{w: out, types: *showTypes}
+	if *gron {
+		pw = &gronWriter{w: out, types: *showTypes}
+	}
+	if *nul {
+		pw = &nulWriter{w: out, types: *showTypes}
+	}
+	if *asCSV {
+		pw = newCSVWriter(out, columns)
+	}
+	if *jsonLines {
+		pw = &jsonLinesWriter{w: out}
+	}
+	if *align {
+		pw = newAlignWriter(out, *showTypes, *window)
+	}
+	if *jcs {
+		pw = &valueWriter{w: out}
+	}
+	if *sortPairs {
+		pw = &sortWriter{pw: pw}
+	}
+	if *sortKeys {
+		pw = newKeySortWriter(pw, *sortMem)
+	}
+	// Set on the first write error, after which output stops.
+	writeFailed := false
+	if *reverse {
+		u := newUnflattener(os.Stdin)
+		u.run(func(value string, err error) {
+			if err != nil {
+				rep.syntaxError(err)
+				return
+			}
+			if _, err := fmt.Fprintln(out, value); err != nil {
+				rep.writeError(err)
+				writeFailed = true
+				u.stop()
+			}
+		})
 	} else {
-		bio := bufio.NewWriter(os.Stdout)
-		defer func() {
-			if err := bio.Flush(); err != nil {
-				// Is standard error going to work any better?
-				log.Printf("Could not flush output: %v", err)
+		f := newFlattener(os.Stdin, opts...)
+		write := func(p pair) {
+			if color {
+				p.value = colorValue(p)
 			}
-		}()
-		out = bio
+			if err := pw.writePair(p); err != nil {
+				rep.writeError(err)
+				writeFailed = true
+				f.stop()
+			}
+		}
+		f.run(func(p pair) {
+			if p.err != nil {
+				rep.syntaxError(p.err)
+				return
+			}
+			if typeNames != nil && !contains(typeNames, jsonType(p.typ)) {
+				return
+			}
+			if vf == nil {
+				write(p)
+				return
+			}
+			for _, p := range vf.filter(p) {
+				if !writeFailed {
+					write(p)
+				}
+			}
+		})
+		if *policy == policyContinue {
+			rep.summary(f.stats())
+		}
 	}
-	f := newFlattener(os.Stdin, opts...)
-	f.run(func(path string, value string, err error) {
-		if err != nil {
-			log.Printf("jf: %v", err)
-			return
+	if fl, ok := pw.(flusher); ok && !writeFailed {
+		if err := fl.flush(); err != nil {
+			rep.writeError(err)
+			writeFailed = true
 		}
-		_, err = fmt.Fprintf(out, "%s\t%s\n", path, value)
-		if err != nil {
-			log.Printf("Could not write to output: %v", err)
-			return
+	}
+	if bio != nil && !writeFailed {
+		if err := bio.Flush(); err != nil {
+			rep.writeError(err)
 		}
+	}
+	return rep.status
+}
+
+// jfSort runs the sort subcommand, which sorts flattened output read
+// from standard input, see sortFlattened, and returns the exit status.
+func jfSort(args []string) int {
+	fs := flag.NewFlagSet("jf sort", flag.ExitOnError)
+	sortMem := fs.Int("sortmem", 64<<20, "hold on to this many `bytes` of lines at most, using temporary files for the rest")
+	_ = fs.Parse(args)
+	rep := newReporter(os.Stderr, false)
+	out := bufio.NewWriter(os.Stdout)
+	writeFailed := false
+	err := sortFlattened(os.Stdin, *sortMem, func(line string) error {
+		_, err := fmt.Fprintln(out, line)
+		writeFailed = err != nil
+		return err
 	})
+	if err == nil {
+		err = out.Flush()
+		writeFailed = err != nil
+	}
+	if _, ok := err.(*tempFileError); ok || writeFailed {
+		rep.writeError(err)
+	} else if err != nil {
+		rep.syntaxError(err)
+	}
+	return rep.status
+}
+
+// usageError reports an error in the command line, followed by the
+// usage message, and returns the exit status for usage errors.
+func usageError(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "jf: "+format+"\n", a...)
+	flag.Usage()
+	return exitUsage
+}
+
+// parseList parses a comma-separated list of names, which must be
+// among the known ones. It returns nil if the list is empty. What the
+// names are for is only used in the error message.
+func parseList(list string, known []string, what string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	names := strings.Split(list, ",")
+	for _, name := range names {
+		if !contains(known, name) {
+			return nil, fmt.Errorf("unknown %s %q, want one of: %s", what, name, strings.Join(known, ", "))
+		}
+	}
+	return names, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func countTrue(flags ...bool) (n int) {
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+	return n
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// normalizeNumber rewrites a number lexeme in a canonical decimal
+// form, so that numbers that are equal are written the same way,
+// e.g., 1.0, 1e0, and 10E-1 are all written as 1. Nothing is lost,
+// however many digits there are: trailing zeros in the fraction and
+// leading zeros are dropped, and the exponent, if any, is adjusted.
+// The form is the one ECMAScript uses for doubles: no exponent for
+// numbers from 1e-6 up to, and excluding, 1e21, e.g., 0.000001 and
+// 123000, and otherwise one digit before the point, e.g., 1.5e+21
+// and 1e-7. Negative zero is written as 0. If keepIntegers is true,
+// numbers without fraction and exponent are left as they are.
+func normalizeNumber(lexeme string, keepIntegers bool) string {
+	s := lexeme
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	mantissa, exp := s, "0"
+	if i := strings.IndexAny(s, "eE"); i != -1 {
+		mantissa, exp = s[:i], s[i+1:]
+	}
+	whole, frac := mantissa, ""
+	if i := strings.IndexByte(mantissa, '.'); i != -1 {
+		whole, frac = mantissa[:i], mantissa[i+1:]
+	} else if keepIntegers && mantissa == s {
+		return lexeme
+	}
+	digits := strings.TrimLeft(whole+frac, "0")
+	if digits == "" {
+		return "0"
+	}
+	// The number is digits × 10^(exp+shift).
+	trimmed := strings.TrimRight(digits, "0")
+	shift := int64(len(digits) - len(trimmed) - len(frac))
+	digits = trimmed
+	// As in ECMAScript's Number::toString, k is the number of digits
+	// and n is where the decimal point goes.
+	k := int64(len(digits))
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	e, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || e > 1<<62 || e < -1<<62 {
+		// Exponents too big for int64 arithmetic are far out of the
+		// range written without exponent.
+		x, _ := new(big.Int).SetString(exp, 10)
+		writeExponential(&b, digits, x.Add(x, big.NewInt(k+shift-1)).String())
+		return b.String()
+	}
+	n := k + e + shift
+	switch {
+	case k <= n && n <= 21:
+		b.WriteString(digits)
+		b.WriteString(strings.Repeat("0", int(n-k)))
+	case 0 < n && n <= 21:
+		b.WriteString(digits[:n])
+		b.WriteByte('.')
+		b.WriteString(digits[n:])
+	case -6 < n && n <= 0:
+		b.WriteString("0.")
+		b.WriteString(strings.Repeat("0", int(-n)))
+		b.WriteString(digits)
+	default:
+		writeExponential(&b, digits, strconv.FormatInt(n-1, 10))
+	}
+	return b.String()
+}
+
+// writeExponential writes digits with one of them before the point,
+// and then exp, the exponent, with its sign, e.g., 1.5e+21.
+func writeExponential(b *strings.Builder, digits, exp string) {
+	b.WriteString(digits[:1])
+	if len(digits) > 1 {
+		b.WriteByte('.')
+		b.WriteString(digits[1:])
+	}
+	b.WriteByte('e')
+	if !strings.HasPrefix(exp, "-") {
+		b.WriteByte('+')
+	}
+	b.WriteString(exp)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := []struct {
+		input        string
+		output       string
+		keepIntegers string // Output with keepIntegers, if different.
+	}{
+		{input: "1", output: "1"},
+		{input: "1.0", output: "1"},
+		{input: "1e0", output: "1"},
+		{input: "10E-1", output: "1"},
+		{input: "0.1e1", output: "1"},
+		{input: "-0", output: "0", keepIntegers: "-0"},
+		{input: "-0.0", output: "0"},
+		{input: "0.00e5", output: "0"},
+		{input: "1.50", output: "1.5"},
+		{input: "1e2", output: "100"},
+		{input: "-2.5E+3", output: "-2500"},
+		{input: "1e21", output: "1e+21"},
+		{input: "1e+0021", output: "1e+21"},
+		{input: "100000000000000000000", output: "100000000000000000000"},
+		{input: "123456789012345678901234567890", output: "1.2345678901234567890123456789e+29", keepIntegers: "123456789012345678901234567890"},
+		{input: "1.000000000000000000000000000001", output: "1.000000000000000000000000000001"},
+		{input: "0.000001", output: "0.000001"},
+		{input: "0.0000012", output: "0.0000012"},
+		{input: "1e-7", output: "1e-7"},
+		{input: "-12.5e-10", output: "-1.25e-9"},
+		{input: "1e999999", output: "1e+999999"},
+		{input: "1E99999999999999999999", output: "1e+99999999999999999999"},
+		{input: "10e99999999999999999998", output: "1e+99999999999999999999"},
+		{input: "-12.50e-99999999999999999999", output: "-1.25e-99999999999999999998"},
+		{input: "1e+99999999999999999999", output: "1e+99999999999999999999"},
+		{input: "0.0e99999999999999999999", output: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := normalizeNumber(tt.input, false); got != tt.output {
+				t.Errorf("got %s, want %s", got, tt.output)
+			}
+			want := tt.keepIntegers
+			if want == "" {
+				want = tt.output
+			}
+			if got := normalizeNumber(tt.input, true); got != want {
+				t.Errorf("keeping integers, got %s, want %s", got, want)
+			}
+			// Nothing is lost.
+			a, aok := new(big.Rat).SetString(tt.input)
+			b, bok := new(big.Rat).SetString(tt.output)
+			if aok && (!bok || a.Cmp(b) != 0) {
+				t.Errorf("%s isn't equal to %s", tt.output, tt.input)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pairWriter writes path-value pairs in one of the output formats.
+type pairWriter interface {
+	writePair(p pair) error
+}
+
+// tsvWriter writes the default format: the path and the value,
+// tab-separated, one pair per line, and optionally the type of the
+// value in a third column.
+type tsvWriter struct {
+	w     io.Writer
+	types bool
+}
+
+func (tw *tsvWriter) writePair(p pair) (err error) {
+	if tw.types {
+		_, err = fmt.Fprintf(tw.w, "%s\t%s\t%s\n", p.path, p.value, jsonType(p.typ))
+	} else {
+		_, err = fmt.Fprintf(tw.w, "%s\t%s\n", p.path, p.value)
+	}
+	return err
+}
+
+// gronWriter writes each pair as an assignment, as in gron, e.g.,
+// json.fruit[0].name = "banana";. It's meant to be used with paths
+// in gronStyle, and the output is valid JavaScript. The type of the
+// value, if requested, goes in a comment.
+type gronWriter struct {
+	w     io.Writer
+	types bool
+}
+
+func (gw *gronWriter) writePair(p pair) (err error) {
+	if gw.types {
+		_, err = fmt.Fprintf(gw.w, "%s = %s; // %s\n", p.path, p.value, jsonType(p.typ))
+	} else {
+		_, err = fmt.Fprintf(gw.w, "%s = %s;\n", p.path, p.value)
+	}
+	return err
+}
+
+// nulWriter writes the path, the value, and optionally the type of
+// the value, each followed by a NUL byte, for xargs -0 and the like.
+// Strings are decoded, so that they can be used as they are. As NUL
+// is the separator, NUL characters in paths and values are written
+// as \u0000.
+type nulWriter struct {
+	w     io.Writer
+	types bool
+}
+
+var nulEscaper = strings.NewReplacer("\x00", `\u0000`)
+
+func (nw *nulWriter) writePair(p pair) (err error) {
+	path, value := nulEscaper.Replace(p.path), nulEscaper.Replace(decodedValue(p))
+	if nw.types {
+		_, err = fmt.Fprintf(nw.w, "%s\x00%s\x00%s\x00", path, value, jsonType(p.typ))
+	} else {
+		_, err = fmt.Fprintf(nw.w, "%s\x00%s\x00", path, value)
+	}
+	return err
+}
+
+// decodedValue returns the value of p, decoded if it's a string.
+func decodedValue(p pair) string {
+	if p.typ == itemQuotedString {
+		return unquote(p.value)
+	}
+	return p.value
+}
+
+// Extra columns for csvWriter.
+var csvColumns = []string{"type", "doc", "depth"}
+
+// csvWriter writes pairs as CSV records (RFC 4180), after a header
+// record. Strings are decoded, as CSV has its own quoting. Besides
+// the path and the value, it can write any of csvColumns: the type
+// of the value, the index of the top-level value the pair belongs to
+// (see -m), and the depth of the path.
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) *csvWriter {
+	return &csvWriter{
+		w:       csv.NewWriter(w),
+		columns: columns,
+	}
+}
+
+func (cw *csvWriter) writePair(p pair) error {
+	if cw.record == nil {
+		cw.record = append([]string{"path", "value"}, cw.columns...)
+		if err := cw.w.Write(cw.record); err != nil {
+			return err
+		}
+	}
+	cw.record = append(cw.record[:0], p.path, decodedValue(p))
+	for _, c := range cw.columns {
+		switch c {
+		case "type":
+			cw.record = append(cw.record, jsonType(p.typ))
+		case "doc":
+			cw.record = append(cw.record, strconv.Itoa(p.doc))
+		case "depth":
+			cw.record = append(cw.record, strconv.Itoa(p.depth))
+		}
+	}
+	if err := cw.w.Write(cw.record); err != nil {
+		return err
+	}
+	// The csv.Writer has its own buffer, which would get in the way
+	// of unbuffered output.
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonLinesWriter writes each pair as a JSON object on a line of its
+// own (JSON Lines), e.g.,
+//
+//	{"path":["fruit",0,"name"],"pointer":"/fruit/0/name","type":"string","value":"banana"}
+//
+// The path is an array of decoded keys and indices, the pointer is
+// the path as a JSON Pointer (RFC 6901). Strings are decoded and
+// encoded again, so that the output is valid JSON even if the input
+// wasn't validated, and containers are written as {} and [].
+type jsonLinesWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (jw *jsonLinesWriter) writePair(p pair) error {
+	b := &jw.buf
+	b.Reset()
+	b.WriteString(`{"path":[`)
+	pointer := pointerStyle{}.root()
+	for i, seg := range p.segs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if seg.key == "" {
+			b.WriteString(strconv.Itoa(seg.index))
+			pointer = pointerStyle{}.index(pointer, seg.index)
+		} else {
+			b.WriteString(quote(unquote(seg.key)))
+			pointer = pointerStyle{}.key(pointer, seg.key)
+		}
+	}
+	b.WriteString(`],"pointer":`)
+	b.WriteString(quote(pointer))
+	b.WriteString(`,"type":"`)
+	b.WriteString(jsonType(p.typ))
+	b.WriteString(`","value":`)
+	if p.typ == itemQuotedString {
+		b.WriteString(quote(unquote(p.value)))
+	} else {
+		b.WriteString(p.value)
+	}
+	b.WriteString("}\n")
+	_, err := jw.w.Write(b.Bytes())
+	return err
+}
+
+// quote encodes s as a JSON string, without escaping HTML characters
+// like the standard library does by default.
+func quote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	// Encoding a string can't fail.
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// flusher is implemented by pair writers that hold on to pairs, which
+// must be flushed after the last one.
+type flusher interface {
+	flush() error
+}
+
+// alignWriter writes the default format, like tsvWriter, but with
+// the value column aligned, by padding paths with spaces to the width
+// of the widest one, as measured in terminal columns. To keep the
+// output streaming, it only looks ahead a bounded number of pairs,
+// the window: pairs are written a window at a time, and the width of
+// the path column is that of the widest path in the window.
+type alignWriter struct {
+	w      io.Writer
+	types  bool
+	window int
+	paths  []string
+	rests  []string // The value, and the type if requested.
+	widths []int
+}
+
+func newAlignWriter(w io.Writer, types bool, window int) *alignWriter {
+	if window < 1 {
+		window = 1
+	}
+	return &alignWriter{w: w, types: types, window: window}
+}
+
+func (aw *alignWriter) writePair(p pair) error {
+	rest := p.value
+	if aw.types {
+		rest += "\t" + jsonType(p.typ)
+	}
+	aw.paths = append(aw.paths, p.path)
+	aw.rests = append(aw.rests, rest)
+	aw.widths = append(aw.widths, displayWidth(p.path))
+	if len(aw.paths) < aw.window {
+		return nil
+	}
+	return aw.flush()
+}
+
+func (aw *alignWriter) flush() error {
+	max := 0
+	for _, w := range aw.widths {
+		if w > max {
+			max = w
+		}
+	}
+	var b bytes.Buffer
+	for i, path := range aw.paths {
+		b.WriteString(path)
+		for n := max - aw.widths[i] + 1; n > 0; n-- {
+			b.WriteByte(' ')
+		}
+		b.WriteString(aw.rests[i])
+		b.WriteByte('\n')
+	}
+	aw.paths, aw.rests, aw.widths = aw.paths[:0], aw.rests[:0], aw.widths[:0]
+	_, err := aw.w.Write(b.Bytes())
+	return err
+}
+
+// sortWriter sorts the pairs of each value by path, then by value,
+// before passing them on to another pair writer, so that the output
+// doesn't depend on the order of object keys or, with wildcards
+// instead of indices, on the order of array elements. It holds on to
+// the pairs of one value at a time.
+type sortWriter struct {
+	pw    pairWriter
+	pairs []pair
+}
+
+func (sw *sortWriter) writePair(p pair) error {
+	if len(sw.pairs) > 0 && sw.pairs[0].doc != p.doc {
+		if err := sw.writePairs(); err != nil {
+			return err
+		}
+	}
+	p.segs = append([]segment(nil), p.segs...)
+	sw.pairs = append(sw.pairs, p)
+	return nil
+}
+
+func (sw *sortWriter) writePairs() error {
+	sort.SliceStable(sw.pairs, func(i, j int) bool {
+		a, b := sw.pairs[i], sw.pairs[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.value < b.value
+	})
+	for _, p := range sw.pairs {
+		if err := sw.pw.writePair(p); err != nil {
+			return err
+		}
+	}
+	sw.pairs = sw.pairs[:0]
+	return nil
+}
+
+func (sw *sortWriter) flush() error {
+	if err := sw.writePairs(); err != nil {
+		return err
+	}
+	if fl, ok := sw.pw.(flusher); ok {
+		return fl.flush()
+	}
+	return nil
+}
+
+// valueWriter only writes the values of pairs, one per line, e.g.,
+// for top-level values in canonical form.
+type valueWriter struct {
+	w io.Writer
+}
+
+func (vw *valueWriter) writePair(p pair) error {
+	_, err := fmt.Fprintln(vw.w, p.value)
+	return err
+}
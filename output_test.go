@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPairWriters(t *testing.T) {
+	input := `{"fruit": [{"name": "banana"}]}`
+	tests := []struct {
+		opts   []option
+		writer func(w *strings.Builder) pairWriter
+		output string
+	}{
+		{
+			writer: func(w *strings.Builder) pairWriter { return &tsvWriter{w: w} },
+			output: ".\t{}\n.\"fruit\"\t[]\n.\"fruit\"[0]\t{}\n.\"fruit\"[0].\"name\"\t\"banana\"\n",
+		},
+		{
+			writer: func(w *strings.Builder) pairWriter { return &tsvWriter{w: w, types: true} },
+			output: ".\t{}\tobject\n.\"fruit\"\t[]\tarray\n.\"fruit\"[0]\t{}\tobject\n.\"fruit\"[0].\"name\"\t\"banana\"\tstring\n",
+		},
+		{
+			opts:   []option{withPathStyle(gronStyle{})},
+			writer: func(w *strings.Builder) pairWriter { return &gronWriter{w: w} },
+			output: "json = {};\njson.fruit = [];\njson.fruit[0] = {};\njson.fruit[0].name = \"banana\";\n",
+		},
+		{
+			opts:   []option{withPathStyle(gronStyle{})},
+			writer: func(w *strings.Builder) pairWriter { return &gronWriter{w: w, types: true} },
+			output: "json = {}; // object\njson.fruit = []; // array\njson.fruit[0] = {}; // object\njson.fruit[0].name = \"banana\"; // string\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			var output strings.Builder
+			pw := tt.writer(&output)
+			writeAll(t, pw, input, tt.opts...)
+			if got := output.String(); got != tt.output {
+				t.Errorf("got %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+func TestNulWriter(t *testing.T) {
+	input := `{"a b": ["x\ty\nz", "café", 1], "n\u0000l": null}`
+	var output strings.Builder
+	pw := &nulWriter{w: &output, types: true}
+	writeAll(t, pw, input, withPathStyle(decodedStyle{}))
+	want := strings.Join([]string{
+		".", "{}", "object",
+		".a b", "[]", "array",
+		".a b[0]", "x\ty\nz", "string",
+		".a b[1]", "café", "string",
+		".a b[2]", "1", "number",
+		`.n\u0000l`, "null", "null",
+	}, "\x00") + "\x00"
+	if got := output.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	input := `{"a": "x,\"y\"", "b": [1, null]} {"c": "line\nbreak"}`
+	var output strings.Builder
+	pw := newCSVWriter(&output, []string{"type", "doc", "depth"})
+	writeAll(t, pw, input, acceptMany)
+	want := `path,value,type,doc,depth
+.,{},object,0,0
+".""a""","x,""y""",string,0,1
+".""b""",[],array,0,1
+".""b""[0]",1,number,0,2
+".""b""[1]",null,null,0,2
+.,{},object,1,0
+".""c""","line
+break",string,1,1
+`
+	if got := output.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJSONLinesWriter(t *testing.T) {
+	input := `{"fruit": [{"name": "banana <&>", "a/b": 1.5e3, "escé": "😀"}], "n": null}`
+	var output strings.Builder
+	pw := &jsonLinesWriter{w: &output}
+	writeAll(t, pw, input)
+	want := `{"path":[],"pointer":"","type":"object","value":{}}
+{"path":["fruit"],"pointer":"/fruit","type":"array","value":[]}
+{"path":["fruit",0],"pointer":"/fruit/0","type":"object","value":{}}
+{"path":["fruit",0,"name"],"pointer":"/fruit/0/name","type":"string","value":"banana <&>"}
+{"path":["fruit",0,"a/b"],"pointer":"/fruit/0/a~1b","type":"number","value":1.5e3}
+{"path":["fruit",0,"escé"],"pointer":"/fruit/0/escé","type":"string","value":"😀"}
+{"path":["n"],"pointer":"/n","type":"null","value":null}
+`
+	if got := output.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAlignWriter(t *testing.T) {
+	input := `{"fruit": [{"name": "banana"}, {"名前": "りんご"}]}`
+	tests := []struct {
+		window int
+		output string
+	}{
+		{
+			window: 1000,
+			output: `.                  {}
+."fruit"           []
+."fruit"[0]        {}
+."fruit"[0]."name" "banana"
+."fruit"[1]        {}
+."fruit"[1]."名前" "りんご"
+`,
+		},
+		{
+			window: 4,
+			output: `.                  {}
+."fruit"           []
+."fruit"[0]        {}
+."fruit"[0]."name" "banana"
+."fruit"[1]        {}
+."fruit"[1]."名前" "りんご"
+`,
+		},
+		{
+			window: 3,
+			output: `.           {}
+."fruit"    []
+."fruit"[0] {}
+."fruit"[0]."name" "banana"
+."fruit"[1]        {}
+."fruit"[1]."名前" "りんご"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			var output strings.Builder
+			aw := newAlignWriter(&output, false, tt.window)
+			writeAll(t, aw, input)
+			if err := aw.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := output.String(); got != tt.output {
+				t.Errorf("got %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+func TestSortWriter(t *testing.T) {
+	// Two values, whose pairs are sorted separately.
+	input := `{"a": [{"n": 2, "t": [1, 2]}, {"n": 1, "t": [3]}], "\u0000": 0} {"b": 1}`
+	want := `.	{}
+."\u0000"	0
+."a"	[]
+."a"[*]	{}
+."a"[*]	{}
+."a"[*]."n"	1
+."a"[*]."n"	2
+."a"[*]."t"	[]
+."a"[*]."t"	[]
+."a"[*]."t"[*]	1
+."a"[*]."t"[*]	2
+."a"[*]."t"[*]	3
+.	{}
+."b"	1
+`
+	var output strings.Builder
+	sw := &sortWriter{pw: &tsvWriter{w: &output}}
+	writeAll(t, sw, input, acceptMany, withWildcards("*"))
+	if err := sw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
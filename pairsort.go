@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// pairSorter sorts pairs. It keeps them in memory up to a limit, then
+// sorts them and spills them to a temporary file, and so on, merging
+// all the sorted runs at the end, fanIn at a time so as not to run out
+// of file descriptors. Sorting is stable.
+type pairSorter struct {
+	less  func(a, b *pair) bool
+	limit int // In bytes, roughly.
+	fanIn int // Number of runs merged at once, at least 2.
+	size  int // Of the pairs in memory.
+	pairs []pair
+	runs  []string // Names of the temporary files, in order.
+}
+
+func newPairSorter(less func(a, b *pair) bool, limit int) *pairSorter {
+	return &pairSorter{less: less, limit: limit, fanIn: 64}
+}
+
+// tempFileError is an error using the temporary files, as opposed to
+// reading the input or writing the output.
+type tempFileError struct {
+	err error
+}
+
+// Error implements error.
+func (e *tempFileError) Error() string {
+	return e.err.Error()
+}
+
+// add adds a pair. Its segments are copied, so it's safe to call add
+// from a flattener callback.
+func (ps *pairSorter) add(p pair) error {
+	p.segs = append([]segment(nil), p.segs...)
+	ps.pairs = append(ps.pairs, p)
+	ps.size += pairSize(p)
+	if ps.size < ps.limit {
+		return nil
+	}
+	return ps.spill()
+}
+
+func pairSize(p pair) int {
+	// Accounts for the pair struct and the segments, very roughly.
+	return 128 + len(p.path) + len(p.value) + 32*len(p.segs)
+}
+
+func (ps *pairSorter) sort() {
+	sort.SliceStable(ps.pairs, func(i, j int) bool {
+		return ps.less(&ps.pairs[i], &ps.pairs[j])
+	})
+}
+
+// spilledPair is how pairs are written to temporary files.
+type spilledPair struct {
+	Path    string
+	Value   string
+	Typ     itemType
+	Doc     int
+	Depth   int
+	Keys    []string
+	Indices []int
+}
+
+// spill writes the pairs in memory, sorted, to a temporary file.
+func (ps *pairSorter) spill() error {
+	ps.sort()
+	rw, err := ps.createRun()
+	if err != nil {
+		return err
+	}
+	for _, p := range ps.pairs {
+		if err := rw.write(p); err != nil {
+			rw.close()
+			return err
+		}
+	}
+	if err := rw.close(); err != nil {
+		return err
+	}
+	ps.pairs, ps.size = ps.pairs[:0], 0
+	return nil
+}
+
+// runWriter writes a sorted run of pairs to a temporary file.
+type runWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *gob.Encoder
+}
+
+// createRun creates a temporary file for a run, after the others.
+func (ps *pairSorter) createRun() (*runWriter, error) {
+	f, err := ioutil.TempFile("", "jf-sort-")
+	if err != nil {
+		return nil, &tempFileError{err}
+	}
+	ps.runs = append(ps.runs, f.Name())
+	w := bufio.NewWriter(f)
+	return &runWriter{f: f, w: w, enc: gob.NewEncoder(w)}, nil
+}
+
+func (rw *runWriter) write(p pair) error {
+	sp := spilledPair{Path: p.path, Value: p.value, Typ: p.typ, Doc: p.doc, Depth: p.depth}
+	for _, seg := range p.segs {
+		sp.Keys = append(sp.Keys, seg.key)
+		sp.Indices = append(sp.Indices, seg.index)
+	}
+	if err := rw.enc.Encode(&sp); err != nil {
+		return &tempFileError{err}
+	}
+	return nil
+}
+
+func (rw *runWriter) close() error {
+	err := rw.w.Flush()
+	if cerr := rw.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return &tempFileError{err}
+	}
+	return nil
+}
+
+// drain passes all pairs added so far to write, in order, and resets
+// the sorter, removing any temporary files.
+func (ps *pairSorter) drain(write func(p pair) error) error {
+	ps.sort()
+	if len(ps.runs) == 0 {
+		for _, p := range ps.pairs {
+			if err := write(p); err != nil {
+				return err
+			}
+		}
+		ps.pairs, ps.size = ps.pairs[:0], 0
+		return nil
+	}
+	defer ps.removeRuns()
+	// Merge the first runs into one, which takes their place, until
+	// the rest can be merged at once, together with the pairs in
+	// memory.
+	for len(ps.runs) >= ps.fanIn {
+		merged := ps.runs[:ps.fanIn]
+		rw, err := ps.createRun()
+		if err != nil {
+			return err
+		}
+		ps.runs = append([]string{ps.runs[len(ps.runs)-1]}, ps.runs[ps.fanIn:len(ps.runs)-1]...)
+		err = ps.merge(merged, nil, rw.write)
+		if cerr := rw.close(); err == nil {
+			err = cerr
+		}
+		removeFiles(merged)
+		if err != nil {
+			return err
+		}
+	}
+	if err := ps.merge(ps.runs, ps.pairs, write); err != nil {
+		return err
+	}
+	ps.pairs, ps.size = ps.pairs[:0], 0
+	return nil
+}
+
+// merge passes the pairs of the runs in the named files, and then
+// those in pairs, to write, in order.
+func (ps *pairSorter) merge(names []string, pairs []pair, write func(p pair) error) error {
+	m := &pairMerger{less: ps.less}
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return &tempFileError{err}
+		}
+		defer f.Close()
+		if err := m.push(&pairRun{dec: gob.NewDecoder(bufio.NewReader(f))}); err != nil {
+			return err
+		}
+	}
+	if err := m.push(&pairRun{pairs: pairs}); err != nil {
+		return err
+	}
+	for m.Len() > 0 {
+		r := m.runs[0]
+		if err := write(r.head); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(m, 0)
+		} else {
+			heap.Pop(m)
+		}
+	}
+	return nil
+}
+
+// close removes any temporary files, for when drain isn't called.
+func (ps *pairSorter) close() {
+	ps.removeRuns()
+}
+
+func (ps *pairSorter) removeRuns() {
+	removeFiles(ps.runs)
+	ps.runs = nil
+}
+
+func removeFiles(names []string) {
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+// pairRun is a sorted run of pairs, either in a temporary file, read
+// through dec, or in memory.
+type pairRun struct {
+	dec   *gob.Decoder
+	pairs []pair
+	head  pair
+	order int // Of the run, to keep the merge stable.
+}
+
+// next moves to the next pair of the run, if any.
+func (r *pairRun) next() (ok bool, err error) {
+	if r.dec == nil {
+		if len(r.pairs) == 0 {
+			return false, nil
+		}
+		r.head, r.pairs = r.pairs[0], r.pairs[1:]
+		return true, nil
+	}
+	var sp spilledPair
+	if err := r.dec.Decode(&sp); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, &tempFileError{err}
+	}
+	r.head = pair{path: sp.Path, value: sp.Value, typ: sp.Typ, doc: sp.Doc, depth: sp.Depth}
+	for i, key := range sp.Keys {
+		r.head.segs = append(r.head.segs, segment{key: key, index: sp.Indices[i]})
+	}
+	return true, nil
+}
+
+// pairMerger is a heap of runs, by their first pairs.
+type pairMerger struct {
+	less func(a, b *pair) bool
+	runs []*pairRun
+}
+
+// push adds a run, unless it's empty.
+func (m *pairMerger) push(r *pairRun) error {
+	r.order = len(m.runs)
+	ok, err := r.next()
+	if err != nil || !ok {
+		return err
+	}
+	heap.Push(m, r)
+	return nil
+}
+
+func (m *pairMerger) Len() int {
+	return len(m.runs)
+}
+
+func (m *pairMerger) Less(i, j int) bool {
+	a, b := m.runs[i], m.runs[j]
+	if m.less(&a.head, &b.head) {
+		return true
+	}
+	if m.less(&b.head, &a.head) {
+		return false
+	}
+	return a.order < b.order
+}
+
+func (m *pairMerger) Swap(i, j int) {
+	m.runs[i], m.runs[j] = m.runs[j], m.runs[i]
+}
+
+func (m *pairMerger) Push(x interface{}) {
+	m.runs = append(m.runs, x.(*pairRun))
+}
+
+func (m *pairMerger) Pop() interface{} {
+	r := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return r
+}
+
+// lessByKeys orders pairs as they'd be flattened if the keys of all
+// objects were sorted: by top-level value, then segment by segment,
+// keys by their decoded strings, indices numerically, and containers
+// before their contents, but after them if the pairs are for their
+// ends (see withSizes).
+func lessByKeys(a, b *pair) bool {
+	if a.doc != b.doc {
+		return a.doc < b.doc
+	}
+	n := len(a.segs)
+	if len(b.segs) < n {
+		n = len(b.segs)
+	}
+	for i := 0; i < n; i++ {
+		if c := compareSegments(a.segs[i], b.segs[i]); c != 0 {
+			return c < 0
+		}
+	}
+	aEnd, bEnd := isEnd(a), isEnd(b)
+	switch {
+	case len(a.segs) < len(b.segs):
+		return !aEnd
+	case len(a.segs) > len(b.segs):
+		return bEnd
+	default:
+		return !aEnd && bEnd
+	}
+}
+
+// compareSegments returns -1, 0, or 1, depending on whether s comes
+// before, together with, or after t. Indices come before keys, which
+// only matters for values that aren't in the same container. Keys
+// that are the same come in the order of their occurrences, as
+// numbered by keyOccurrences.
+func compareSegments(s, t segment) int {
+	switch {
+	case s.key == "" && t.key == "":
+		switch {
+		case s.index < t.index:
+			return -1
+		case s.index > t.index:
+			return 1
+		}
+		return 0
+	case s.key == "":
+		return -1
+	case t.key == "":
+		return 1
+	}
+	if s.key != t.key {
+		k, l := unquote(s.key), unquote(t.key)
+		switch {
+		case k < l:
+			return -1
+		case k > l:
+			return 1
+		}
+	}
+	switch {
+	case s.index < t.index:
+		return -1
+	case s.index > t.index:
+		return 1
+	}
+	return 0
+}
+
+// isEnd tells whether p is for the end of an object or an array.
+func isEnd(p *pair) bool {
+	return p.typ == itemRightCurlyBrace || p.typ == itemRightBracket
+}
+
+// keyOccurrences numbers the occurrences of the keys of each object,
+// so that the values of duplicate keys, e.g., those of "a" in
+// {"a":{"x":1},"a":{"w":2}}, aren't mixed up when sorting, but kept
+// whole and in the order they come. The numbers go in the indices of
+// the key segments, which are otherwise unused. Pairs must come in the
+// order the flattener passes them, those of objects included: it's
+// for flattened output read back, while the flattener numbers keys
+// itself, see numberKeys.
+type keyOccurrences struct {
+	counts []map[string]int // Of the objects containing the last pair, by depth.
+	last   []int            // Numbers of the segments of the last pair.
+}
+
+// number sets the indices of the key segments of p, which it modifies
+// in place.
+func (ko *keyOccurrences) number(p *pair) {
+	n := len(p.segs)
+	if !isEnd(p) {
+		// The last segment is new, the others are those of the
+		// containers of p, seen already.
+		for len(ko.counts) < n {
+			ko.counts = append(ko.counts, nil)
+		}
+		for len(ko.last) < n {
+			ko.last = append(ko.last, 0)
+		}
+		ko.counts, ko.last = ko.counts[:n], ko.last[:n]
+		if n > 0 {
+			ko.last[n-1] = 0
+			if seg := p.segs[n-1]; seg.key != "" {
+				key := unquote(seg.key)
+				if ko.counts[n-1] == nil {
+					ko.counts[n-1] = make(map[string]int)
+				}
+				ko.last[n-1] = ko.counts[n-1][key]
+				ko.counts[n-1][key]++
+			}
+		}
+		// For the contents of p, if any.
+		ko.counts = append(ko.counts, nil)
+	}
+	for i := range p.segs {
+		if p.segs[i].key != "" && i < len(ko.last) {
+			p.segs[i].index = ko.last[i]
+		}
+	}
+}
+
+// keySortWriter writes pairs as if the keys of all objects were sorted,
+// by passing them on to another pair writer in the order given by
+// lessByKeys. Only the pairs of values inside objects need to be held
+// on to: the pairs of values that are only inside arrays, such as the
+// elements of a top-level array, are written as soon as they come,
+// together with those held on to so far, as nothing that follows can
+// come before them. The keys must have been numbered, see numberKeys.
+type keySortWriter struct {
+	pw     pairWriter
+	sorter *pairSorter
+}
+
+func newKeySortWriter(pw pairWriter, limit int) *keySortWriter {
+	return &keySortWriter{pw: pw, sorter: newPairSorter(lessByKeys, limit)}
+}
+
+func (kw *keySortWriter) writePair(p pair) error {
+	err := kw.writeOrHold(p)
+	if err != nil {
+		kw.sorter.close()
+	}
+	return err
+}
+
+func (kw *keySortWriter) writeOrHold(p pair) error {
+	for _, seg := range p.segs {
+		if seg.key != "" {
+			p.segs = append([]segment(nil), p.segs...)
+			return kw.sorter.add(p)
+		}
+	}
+	if err := kw.sorter.drain(kw.pw.writePair); err != nil {
+		return err
+	}
+	return kw.pw.writePair(p)
+}
+
+func (kw *keySortWriter) flush() error {
+	if err := kw.sorter.drain(kw.pw.writePair); err != nil {
+		kw.sorter.close()
+		return err
+	}
+	if fl, ok := kw.pw.(flusher); ok {
+		return fl.flush()
+	}
+	return nil
+}
+
+// sortFlattened reads flattened output, as written by tsvWriter, and
+// passes its lines to write sorted as lessByKeys says, i.e., by
+// top-level value, then by path, indices numerically and keys by
+// their decoded strings, keeping the order of lines with the same
+// path, and of the values of duplicate keys. Lines with the root path
+// start new values, as for the unflattener. Lines that don't fit in
+// limit bytes are sorted with the help of temporary files.
+func sortFlattened(r io.Reader, limit int, write func(line string) error) error {
+	sorter := newPairSorter(lessByKeys, limit)
+	defer sorter.close()
+	br := bufio.NewReader(r)
+	var keys keyOccurrences
+	doc := -1
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			segs, rest, perr := parsePathPrefix(line)
+			if perr != nil {
+				return fmt.Errorf("line %d: %v", n, perr)
+			}
+			if !strings.HasPrefix(rest, "\t") {
+				return fmt.Errorf("line %d: expected tab after path, got: %q", n, rest)
+			}
+			p := pair{path: line[:len(line)-len(rest)], value: rest[1:], segs: segs}
+			// Lines ending containers, as printed with -c.
+			switch {
+			case strings.HasPrefix(p.value, "}"):
+				p.typ = itemRightCurlyBrace
+			case strings.HasPrefix(p.value, "]"):
+				p.typ = itemRightBracket
+			}
+			if len(segs) == 0 && !isEnd(&p) || doc == -1 {
+				doc++
+			}
+			p.doc = doc
+			keys.number(&p)
+			if err := sorter.add(p); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return sorter.drain(func(p pair) error {
+		return write(p.path + "\t" + p.value)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeySortWriter(t *testing.T) {
+	input := `{"z": {"b": [{"y": 1, "x": 2}, 3], "a": null}, "ab": 1, "ab": 0, "é": 1, "a": []} [{"b": 1, "a": 2}, 4] {"a": {"x": 1, "z": 0}, "b": 0, "a": {"w": 2}}`
+	want := `.	{}
+."a"	[]
+."a"	]0
+."ab"	1
+."ab"	0
+."z"	{}
+."z"."a"	null
+."z"."b"	[]
+."z"."b"[0]	{}
+."z"."b"[0]."x"	2
+."z"."b"[0]."y"	1
+."z"."b"[0]	}2
+."z"."b"[1]	3
+."z"."b"	]2
+."z"	}2
+."é"	1
+.	}5
+.	[]
+.[0]	{}
+.[0]."a"	2
+.[0]."b"	1
+.[0]	}2
+.[1]	4
+.	]2
+.	{}
+."a"	{}
+."a"."x"	1
+."a"."z"	0
+."a"	}2
+."a"	{}
+."a"."w"	2
+."a"	}1
+."b"	0
+.	}3
+`
+	// With a limit of 1 byte, each pair is spilled to a file of its
+	// own, and with a fan-in of 2, runs are merged two at a time.
+	for _, tt := range []struct{ limit, fanIn int }{{1 << 20, 64}, {1, 64}, {1, 2}} {
+		var output strings.Builder
+		kw := newKeySortWriter(&tsvWriter{w: &output}, tt.limit)
+		kw.sorter.fanIn = tt.fanIn
+		writeAll(t, kw, input, acceptMany, withSizes, numberKeys)
+		if err := kw.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := output.String(); got != want {
+			t.Errorf("limit %d, fan-in %d: got %q, want %q", tt.limit, tt.fanIn, got, want)
+		}
+		if len(kw.sorter.runs) != 0 {
+			t.Errorf("limit %d, fan-in %d: got %d runs left, want none", tt.limit, tt.fanIn, len(kw.sorter.runs))
+		}
+	}
+}
+
+func TestKeySortWriterLeaves(t *testing.T) {
+	// Without the pairs of the objects, the values of duplicate keys
+	// are told apart by the numbers the flattener gives them.
+	input := `{"x": {"a": 1, "b": 2}, "x": {"a": 3}}`
+	want := `."x"."a"	1
+."x"."b"	2
+."x"."a"	3
+`
+	var output strings.Builder
+	kw := newKeySortWriter(&tsvWriter{w: &output}, 1<<20)
+	writeAll(t, kw, input, leavesOnly, numberKeys)
+	if err := kw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSortFlattened(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		err    string
+	}{
+		{
+			// Duplicate keys keep their values whole, and indices,
+			// even out of order, are sorted numerically.
+			input:  ".\t[]\n.[0]\t{}\n.[0].\"b\"\t1\n.[0].\"a\"\t{}\n.[0].\"a\".\"y\"\t3\n.[0].\"\\u0061\"\t4\n.[10]\t2\n.[1]\t0\n.[2]\t0\n",
+			output: ".\t[]\n.[0]\t{}\n.[0].\"a\"\t{}\n.[0].\"a\".\"y\"\t3\n.[0].\"\\u0061\"\t4\n.[0].\"b\"\t1\n.[1]\t0\n.[2]\t0\n.[10]\t2\n",
+		},
+		{
+			// Values are kept apart, and so are the lines ending containers.
+			input:  ".\t{}\n.\"b\"\t1\tnumber\n.\"a\"\t2\tnumber\n.\t}2\n.\t{}\n.\"c\"\t[]\n.\"c\"\t]0\n.\"a\"\t3\n",
+			output: ".\t{}\n.\"a\"\t2\tnumber\n.\"b\"\t1\tnumber\n.\t}2\n.\t{}\n.\"a\"\t3\n.\"c\"\t[]\n.\"c\"\t]0\n",
+		},
+		{
+			// Lines can end with CRLF, as for the unflattener.
+			input:  ".\t{}\r\n.\"b\"\t1\r\n.\"a\"\t2\r\n",
+			output: ".\t{}\n.\"a\"\t2\n.\"b\"\t1\n",
+		},
+		{
+			input: ".\t1\n.[x]\t2\n",
+			err:   `line 2: parsePath: bad index: "[x]"`,
+		},
+		{
+			input: ".\"a\" 1\n",
+			err:   `line 1: expected tab after path, got: " 1"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			for _, limit := range []int{1 << 20, 1} {
+				var output strings.Builder
+				err := sortFlattened(strings.NewReader(tt.input), limit, func(line string) error {
+					output.WriteString(line + "\n")
+					return nil
+				})
+				if tt.err != "" {
+					if err == nil {
+						t.Fatalf("got nil, want %q", tt.err)
+					}
+					if got := err.Error(); got != tt.err {
+						t.Errorf("got %q, want %q", got, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := output.String(); got != tt.output {
+					t.Errorf("limit %d: got %q, want %q", limit, got, tt.output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment is one step of a path: either an object key or an array
+// index. Keys are kept as the quoted string lexemes found in the
+// input, quotes and escapes included, so an empty key means the
+// segment is an array index. The index of a key is zero, except when
+// sorting, see keyOccurrences.
+type segment struct {
+	key   string
+	index int
+}
+
+// String implements fmt.Stringer.
+func (s segment) String() string {
+	if s.key == "" {
+		return fmt.Sprintf("[%d]", s.index)
+	}
+	return s.key
+}
+
+// pathStyle builds paths, starting from the path of the root value
+// and adding one segment at a time, as the flattener descends into
+// objects and arrays.
+type pathStyle interface {
+	root() string
+	// Keys are given as quoted string lexemes.
+	key(parent string, key string) string
+	index(parent string, index int) string
+	// Like index, but with a placeholder instead of the index,
+	// e.g., *, see withWildcards.
+	wildcard(parent string, placeholder string) string
+}
+
+// jfStyle is the default style, e.g., ."fruit"[0]."name". Keys are
+// written as found in the input, so paths are unambiguous, and can be
+// parsed back by parsePath.
+type jfStyle struct{}
+
+func (jfStyle) root() string {
+	return "."
+}
+
+func (jfStyle) key(parent string, key string) string {
+	if parent == "." {
+		return parent + key
+	}
+	return parent + "." + key
+}
+
+func (jfStyle) index(parent string, index int) string {
+	return parent + "[" + strconv.Itoa(index) + "]"
+}
+
+func (jfStyle) wildcard(parent string, placeholder string) string {
+	return parent + "[" + placeholder + "]"
+}
+
+// pointerStyle writes paths as JSON Pointers (RFC 6901), e.g.,
+// /fruit/0/name. Keys are decoded, then ~ and / are escaped as ~0
+// and ~1. The root pointer is the empty string.
+type pointerStyle struct{}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func (pointerStyle) root() string {
+	return ""
+}
+
+func (pointerStyle) key(parent string, key string) string {
+	return parent + "/" + pointerEscaper.Replace(unquote(key))
+}
+
+func (pointerStyle) index(parent string, index int) string {
+	return parent + "/" + strconv.Itoa(index)
+}
+
+func (pointerStyle) wildcard(parent string, placeholder string) string {
+	return parent + "/" + pointerEscaper.Replace(placeholder)
+}
+
+// decodedStyle is like jfStyle, but keys are decoded and written
+// without quotes, e.g., .fruit[0].name. Such paths are ambiguous, as
+// keys can contain dots and brackets, but they are handy when keys
+// are known to be simple.
+type decodedStyle struct{}
+
+func (decodedStyle) root() string {
+	return "."
+}
+
+func (decodedStyle) key(parent string, key string) string {
+	if parent == "." {
+		return parent + unquote(key)
+	}
+	return parent + "." + unquote(key)
+}
+
+func (decodedStyle) index(parent string, index int) string {
+	return parent + "[" + strconv.Itoa(index) + "]"
+}
+
+func (decodedStyle) wildcard(parent string, placeholder string) string {
+	return parent + "[" + placeholder + "]"
+}
+
+// gronStyle writes paths as JavaScript expressions, as in gron, e.g.,
+// json.fruit[0].name. Keys that are identifiers, once decoded, are
+// written after a dot, the others are written as found in the input,
+// in brackets. Identifiers here are those that are valid both in
+// JavaScript and in jq, so the paths work with either.
+type gronStyle struct{}
+
+func (gronStyle) root() string {
+	return "json"
+}
+
+func (gronStyle) key(parent string, key string) string {
+	if k := unquote(key); isIdentifier(k) {
+		return parent + "." + k
+	}
+	return parent + "[" + key + "]"
+}
+
+func (gronStyle) index(parent string, index int) string {
+	return parent + "[" + strconv.Itoa(index) + "]"
+}
+
+func (gronStyle) wildcard(parent string, placeholder string) string {
+	return parent + "[" + placeholder + "]"
+}
+
+// isIdentifier tells whether s matches [A-Za-z_][A-Za-z0-9_]*.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range []byte(s) {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parsePath parses a path as produced by flattenObject and
+// flattenArray, e.g., ."fruit"[0]."name", and returns its segments.
+func parsePath(path string) ([]segment, error) {
+	segs, rest, err := parsePathPrefix(path)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("parsePath: trailing garbage after path: %q", rest)
+	}
+	return segs, nil
+}
+
+// parsePathPrefix is like parsePath, but only parses the longest
+// prefix of s that is a path, and returns the remainder of s.
+func parsePathPrefix(s string) (segs []segment, rest string, err error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, s, fmt.Errorf("parsePath: path must start with a dot: %q", s)
+	}
+	rest = s[1:]
+	// The first key follows the root dot directly, the others are
+	// preceded by a dot of their own.
+	needDot := false
+	for rest != "" {
+		switch rest[0] {
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, rest, fmt.Errorf("parsePath: unterminated index: %q", rest)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, rest, fmt.Errorf("parsePath: bad index: %q", rest[:end+1])
+			}
+			segs = append(segs, segment{index: index})
+			rest = rest[end+1:]
+			needDot = true
+		case '.', '"':
+			if needDot {
+				if rest[0] != '.' {
+					return segs, rest, nil
+				}
+				rest = rest[1:]
+			}
+			key, err := quotedPrefix(rest)
+			if err != nil {
+				return nil, rest, err
+			}
+			segs = append(segs, segment{key: key})
+			rest = rest[len(key):]
+			needDot = true
+		default:
+			return segs, rest, nil
+		}
+	}
+	return segs, rest, nil
+}
+
+// quotedPrefix returns the quoted string at the start of s, quotes
+// included, honouring backslash escapes.
+func quotedPrefix(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", fmt.Errorf("parsePath: expected quoted key: %q", s)
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[:i+1], nil
+		}
+	}
+	return "", fmt.Errorf("parsePath: unterminated key: %q", s)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		segs []segment
+		err  string
+	}{
+		{path: "."},
+		{path: `."fruit"`, segs: []segment{{key: `"fruit"`}}},
+		{path: ".[3]", segs: []segment{{index: 3}}},
+		{
+			path: `."fruit"[0]."name"`,
+			segs: []segment{{key: `"fruit"`}, {index: 0}, {key: `"name"`}},
+		},
+		{
+			path: `."a.b"."c\"[0]"[12][1]`,
+			segs: []segment{{key: `"a.b"`}, {key: `"c\"[0]"`}, {index: 12}, {index: 1}},
+		},
+		{path: "", err: `parsePath: path must start with a dot: ""`},
+		{path: `."a"[x]`, err: `parsePath: bad index: "[x]"`},
+		{path: `."a`, err: `parsePath: unterminated key: "\"a"`},
+		{path: `."a""b"`, err: `parsePath: trailing garbage after path: "\"b\""`},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			segs, err := parsePath(tt.path)
+			if tt.err != "" {
+				if err == nil {
+					t.Fatalf("got nil, want %q", tt.err)
+				}
+				if got := err.Error(); got != tt.err {
+					t.Errorf("got %q, want %q", got, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.segs, segs, cmp.AllowUnexported(segment{})); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestPointerStyle(t *testing.T) {
+	input := `{"fruit": [{"name": "banana"}], "a/b": {"m~n": 1, "caf\u00e9": 2, "": 3}}`
+	var paths []string
+	for _, p := range newFlattener(strings.NewReader(input), withPathStyle(pointerStyle{})).collect() {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		paths = append(paths, p.path)
+	}
+	want := []string{
+		"",
+		"/fruit",
+		"/fruit/0",
+		"/fruit/0/name",
+		"/a~1b",
+		"/a~1b/m~0n",
+		"/a~1b/café",
+		"/a~1b/",
+	}
+	if diff := cmp.Diff(want, paths); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestGronStyle(t *testing.T) {
+	input := `{"fruit": [{"name": "banana"}], "a b": {"_x1": 1, "1x": 2, "café": 3, "name": 4}}`
+	var paths []string
+	for _, p := range newFlattener(strings.NewReader(input), withPathStyle(gronStyle{})).collect() {
+		if p.err != nil {
+			t.Fatal(p.err)
+		}
+		paths = append(paths, p.path)
+	}
+	want := []string{
+		"json",
+		"json.fruit",
+		"json.fruit[0]",
+		"json.fruit[0].name",
+		`json["a b"]`,
+		`json["a b"]._x1`,
+		`json["a b"]["1x"]`,
+		`json["a b"]["café"]`,
+		`json["a b"].name`,
+	}
+	if diff := cmp.Diff(want, paths); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pattern is a path glob, e.g., ."items"[*]."login", or **."url".
+// Besides the segments of paths as built by jfStyle, a pattern can
+// contain:
+//
+//	.*	any key
+//	[*]	any index
+//	[i:j]	any index from i (default 0) up to j (default unlimited), j excluded
+//	**	any number of segments, including none
+type pattern []patternElem
+
+type patternElem struct {
+	kind patternKind
+	key  string // Decoded, for patternKey.
+	lo   int    // For patternIndex.
+	hi   int    // For patternIndex, excluded, negative for no limit.
+}
+
+type patternKind int
+
+const (
+	patternKey patternKind = iota
+	patternAnyKey
+	patternIndex
+	patternAnyDepth
+)
+
+// parsePattern parses a pattern. Dots between elements are optional,
+// so that, e.g., **."url" and **"url" are the same pattern.
+func parsePattern(s string) (pattern, error) {
+	var p pattern
+	for rest := s; rest != ""; {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "**"):
+			p = append(p, patternElem{kind: patternAnyDepth})
+			rest = rest[2:]
+		case rest[0] == '*':
+			p = append(p, patternElem{kind: patternAnyKey})
+			rest = rest[1:]
+		case rest[0] == '"':
+			key, err := quotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("parsePattern: %q: %v", s, err)
+			}
+			p = append(p, patternElem{kind: patternKey, key: unquote(key)})
+			rest = rest[len(key):]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("parsePattern: %q: unterminated index: %q", s, rest)
+			}
+			e, err := parseIndexRange(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("parsePattern: %q: bad index: %q", s, rest[:end+1])
+			}
+			p = append(p, e)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("parsePattern: %q: unexpected %q", s, rest)
+		}
+	}
+	return p, nil
+}
+
+// exactPattern returns the pattern that only matches the path made
+// of segs.
+func exactPattern(segs []segment) pattern {
+	p := make(pattern, len(segs))
+	for i, seg := range segs {
+		if seg.key == "" {
+			p[i] = patternElem{kind: patternIndex, lo: seg.index, hi: seg.index + 1}
+		} else {
+			p[i] = patternElem{kind: patternKey, key: unquote(seg.key)}
+		}
+	}
+	return p
+}
+
+// parseIndexRange parses what's between the brackets of an index
+// pattern: *, i, or i:j.
+func parseIndexRange(s string) (patternElem, error) {
+	e := patternElem{kind: patternIndex, hi: -1}
+	if s == "*" {
+		return e, nil
+	}
+	lo, hi, isRange := s, "", false
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		lo, hi, isRange = s[:i], s[i+1:], true
+	}
+	var err error
+	if lo != "" || !isRange {
+		if e.lo, err = strconv.Atoi(lo); err != nil || e.lo < 0 {
+			return e, fmt.Errorf("bad index %q", lo)
+		}
+	}
+	if !isRange {
+		e.hi = e.lo + 1
+	} else if hi != "" {
+		if e.hi, err = strconv.Atoi(hi); err != nil || e.hi < 0 {
+			return e, fmt.Errorf("bad index %q", hi)
+		}
+	}
+	return e, nil
+}
+
+// match tells whether p matches the path made of segs. If partial is
+// true, it tells instead whether p could match a path that starts
+// with segs.
+func (p pattern) match(segs []segment, partial bool) bool {
+	for len(p) > 0 {
+		e := p[0]
+		if e.kind == patternAnyDepth {
+			return p[1:].match(segs, partial) || len(segs) > 0 && p.match(segs[1:], partial)
+		}
+		if len(segs) == 0 {
+			return partial
+		}
+		if !e.matches(segs[0]) {
+			return false
+		}
+		p, segs = p[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+func (e patternElem) matches(seg segment) bool {
+	switch e.kind {
+	case patternKey:
+		return seg.key != "" && unquote(seg.key) == e.key
+	case patternAnyKey:
+		return seg.key != ""
+	case patternIndex:
+		return seg.key == "" && seg.index >= e.lo && (e.hi < 0 || seg.index < e.hi)
+	default:
+		return false
+	}
+}
+
+// patterns is a flag.Value for options that can be given many times,
+// each time with a pattern.
+type patterns []pattern
+
+func (ps *patterns) String() string {
+	return ""
+}
+
+func (ps *patterns) Set(s string) error {
+	p, err := parsePattern(s)
+	if err != nil {
+		return err
+	}
+	*ps = append(*ps, p)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+		partial bool // Whether the pattern can match below the path.
+	}{
+		{pattern: ".", path: ".", match: true, partial: true},
+		{pattern: ".", path: `."a"`},
+		{pattern: `."a"`, path: ".", partial: true},
+		{pattern: `."a"`, path: `."a"`, match: true, partial: true},
+		{pattern: `."\u0061"`, path: `."a"`, match: true, partial: true},
+		{pattern: `."a"`, path: `."b"`},
+		{pattern: `."a"`, path: ".[0]"},
+		{pattern: `.*`, path: `."b"`, match: true, partial: true},
+		{pattern: `.*`, path: ".[0]"},
+		{pattern: `."items"[*]."login"`, path: `."items"[7]`, partial: true},
+		{pattern: `."items"[*]."login"`, path: `."items"[7]."login"`, match: true, partial: true},
+		{pattern: `."items"[*]."login"`, path: `."items"."x"`},
+		{pattern: "[2]", path: ".[2]", match: true, partial: true},
+		{pattern: "[2]", path: ".[3]"},
+		{pattern: "[0:10]", path: ".[9]", match: true, partial: true},
+		{pattern: "[0:10]", path: ".[10]"},
+		{pattern: "[:2]", path: ".[1]", match: true, partial: true},
+		{pattern: "[5:]", path: ".[4]"},
+		{pattern: "[5:]", path: ".[500]", match: true, partial: true},
+		{pattern: `**."url"`, path: `."url"`, match: true, partial: true},
+		{pattern: `**."url"`, path: `."a"[1]."url"`, match: true, partial: true},
+		{pattern: `**."url"`, path: `."a"[1]`, partial: true},
+		{pattern: `**."url"`, path: `."url"."x"`, partial: true},
+		{pattern: `."a"**`, path: `."a"[1]."b"`, match: true, partial: true},
+		{pattern: `."a"**`, path: `."a"`, match: true, partial: true},
+		{pattern: `."a"**`, path: `."b"`},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			p, err := parsePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			segs, err := parsePath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.match(segs, false); got != tt.match {
+				t.Errorf("%s matching %s: got %v, want %v", tt.pattern, tt.path, got, tt.match)
+			}
+			if got := p.match(segs, true); got != tt.partial {
+				t.Errorf("%s partially matching %s: got %v, want %v", tt.pattern, tt.path, got, tt.partial)
+			}
+		})
+	}
+}
+
+func TestParsePatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     string
+	}{
+		{pattern: `."a`, err: `parsePattern: ".\"a": parsePath: unterminated key: "\"a"`},
+		{pattern: "[x]", err: `parsePattern: "[x]": bad index: "[x]"`},
+		{pattern: "[1:x]", err: `parsePattern: "[1:x]": bad index: "[1:x]"`},
+		{pattern: "[1", err: `parsePattern: "[1": unterminated index: "[1"`},
+		{pattern: "a", err: `parsePattern: "a": unexpected "a"`},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			_, err := parsePattern(tt.pattern)
+			if err == nil {
+				t.Fatalf("got nil, want %q", tt.err)
+			}
+			if got := err.Error(); got != tt.err {
+				t.Errorf("got %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"syscall"
+)
+
+// Exit statuses. Status 2 is what the flag package uses for usage
+// errors.
+const (
+	exitOK     = 0
+	exitSyntax = 1
+	exitUsage  = 2
+	exitWrite  = 3
+)
+
+// Error policies, see the -e flag.
+const (
+	policyFail     = "fail"
+	policyContinue = "continue"
+)
+
+// reporter writes errors and summaries to standard error, either as
+// text for people or as JSON objects, one per line, for programs.
+// It also keeps track of the exit status.
+type reporter struct {
+	w      io.Writer
+	log    *log.Logger
+	json   bool
+	status int
+}
+
+func newReporter(w io.Writer, asJSON bool) *reporter {
+	return &reporter{
+		w:    w,
+		log:  log.New(w, "", log.LstdFlags),
+		json: asJSON,
+	}
+}
+
+// errorRecord is how errors and summaries are written in JSON.
+type errorRecord struct {
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Offset  *int64 `json:"offset,omitempty"`
+	Good    *int   `json:"good,omitempty"`
+	Bad     *int   `json:"bad,omitempty"`
+}
+
+func (r *reporter) writeJSON(rec errorRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		// Can't happen, all fields can be marshaled.
+		panic(err)
+	}
+	_, _ = fmt.Fprintf(r.w, "%s\n", b)
+}
+
+// syntaxError reports an error in the input.
+func (r *reporter) syntaxError(err error) {
+	r.setStatus(exitSyntax)
+	se, _ := err.(*syntaxError)
+	if r.json {
+		rec := errorRecord{Type: "syntax", Message: err.Error()}
+		if se != nil {
+			rec.Message = se.msg
+			rec.Line = se.pos.line
+			rec.Column = se.pos.column
+			rec.Offset = &se.pos.offset
+		}
+		r.writeJSON(rec)
+		return
+	}
+	r.log.Printf("jf: %v", err)
+	if se != nil && se.excerpt != "" {
+		_, _ = fmt.Fprintln(r.w, se.context())
+	}
+}
+
+// writeError reports an error writing the output, or using the
+// temporary files that sorting needs. A broken pipe is not reported,
+// as it only means that the reader has gone away, e.g., jf's output
+// was piped into sed 2q.
+func (r *reporter) writeError(err error) {
+	if isBrokenPipe(err) {
+		return
+	}
+	r.setStatus(exitWrite)
+	if r.json {
+		r.writeJSON(errorRecord{Type: "write", Message: err.Error()})
+		return
+	}
+	if _, ok := err.(*tempFileError); ok {
+		r.log.Printf("Could not use temporary file: %v", err)
+		return
+	}
+	r.log.Printf("Could not write to output: %v", err)
+}
+
+// summary reports the number of values flattened and skipped.
+func (r *reporter) summary(good, bad int) {
+	if r.json {
+		r.writeJSON(errorRecord{Type: "summary", Good: &good, Bad: &bad})
+		return
+	}
+	r.log.Printf("jf: %d good records, %d bad records", good, bad)
+}
+
+// setStatus sets the exit status, unless an error has been reported
+// already: the first error determines the exit status.
+func (r *reporter) setStatus(status int) {
+	if r.status == exitOK {
+		r.status = status
+	}
+}
+
+func isBrokenPipe(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.EPIPE
+}
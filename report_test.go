@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestReporterJSON(t *testing.T) {
+	var stderr bytes.Buffer
+	rep := newReporter(&stderr, true)
+	newFlattener(strings.NewReader(`{"a": tru}`)).run(func(p pair) {
+		if p.err != nil {
+			rep.syntaxError(p.err)
+		}
+	})
+	rep.writeError(errors.New("disk full"))
+	rep.summary(2, 1)
+	want := `{"type":"syntax","message":"flattenValue: lexer error: bad literal \"tru\": expected true, false, or null","line":1,"column":7,"offset":6}
+{"type":"write","message":"disk full"}
+{"type":"summary","good":2,"bad":1}
+`
+	if got := stderr.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := rep.status, exitSyntax; got != want {
+		t.Errorf("got %d, want %d exit status", got, want)
+	}
+}
+
+func TestReporterBrokenPipe(t *testing.T) {
+	var stderr bytes.Buffer
+	rep := newReporter(&stderr, false)
+	rep.writeError(&os.PathError{Op: "write", Path: "/dev/stdout", Err: syscall.EPIPE})
+	if stderr.Len() != 0 {
+		t.Errorf("got %q, want no output", stderr.String())
+	}
+	if got, want := rep.status, exitOK; got != want {
+		t.Errorf("got %d, want %d exit status", got, want)
+	}
+	rep.writeError(errors.New("disk full"))
+	if got, want := rep.status, exitWrite; got != want {
+		t.Errorf("got %d, want %d exit status", got, want)
+	}
+}
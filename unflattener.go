@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type nodeKind int
+
+const (
+	nodeScalar nodeKind = iota
+	nodeObject
+	nodeArray
+)
+
+// node is a JSON value rebuilt from path-value pairs. Scalars are
+// kept as the lexemes found in the input. Object keys are kept in
+// the order they were first seen. Array elements are kept by index,
+// and written in index order, so gaps left by edited input are
+// closed up.
+type node struct {
+	kind   nodeKind
+	value  string
+	keys   []string
+	fields map[string]*node
+	elems  map[int]*node
+}
+
+func newNode(kind nodeKind) *node {
+	n := &node{kind: kind}
+	switch kind {
+	case nodeObject:
+		n.fields = make(map[string]*node)
+	case nodeArray:
+		n.elems = make(map[int]*node)
+	}
+	return n
+}
+
+// child returns the child of n at seg, creating it with the given
+// kind if it does not exist yet.
+func (n *node) child(seg segment, kind nodeKind) (*node, error) {
+	if seg.key != "" {
+		if n.kind != nodeObject {
+			return nil, fmt.Errorf("key %s in a non-object", seg)
+		}
+		c, ok := n.fields[seg.key]
+		if !ok {
+			c = newNode(kind)
+			n.fields[seg.key] = c
+			n.keys = append(n.keys, seg.key)
+		}
+		return c, nil
+	}
+	if n.kind != nodeArray {
+		return nil, fmt.Errorf("index %s in a non-array", seg)
+	}
+	c, ok := n.elems[seg.index]
+	if !ok {
+		c = newNode(kind)
+		n.elems[seg.index] = c
+	}
+	return c, nil
+}
+
+// kindFor returns the kind of container that can hold seg.
+func kindFor(seg segment) nodeKind {
+	if seg.key != "" {
+		return nodeObject
+	}
+	return nodeArray
+}
+
+// writeTo writes n as compact JSON.
+func (n *node) writeTo(b *bytes.Buffer) {
+	switch n.kind {
+	case nodeScalar:
+		b.WriteString(n.value)
+	case nodeObject:
+		b.WriteByte('{')
+		for i, k := range n.keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(k)
+			b.WriteByte(':')
+			n.fields[k].writeTo(b)
+		}
+		b.WriteByte('}')
+	case nodeArray:
+		indices := make([]int, 0, len(n.elems))
+		for i := range n.elems {
+			indices = append(indices, i)
+		}
+		sort.Ints(indices)
+		b.WriteByte('[')
+		for i, index := range indices {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			n.elems[index].writeTo(b)
+		}
+		b.WriteByte(']')
+	}
+}
+
+// unflattener is the inverse of the flattener: it reads path-value
+// pairs, one per line, tab-separated, and rebuilds the JSON values
+// they came from. Columns after the value, if any, are ignored. Each
+// line whose path is the root path starts a new value, which is how
+// the values in a stream produced with -m are told apart. Container
+// lines ({} and []) may be missing, e.g., because they were filtered
+// out, as long as the kind of container can be told from the paths
+// of its children.
+//
+// Each value is kept in memory until the next one starts, as there's
+// no telling when a value is complete otherwise.
+type unflattener struct {
+	input *bufio.Reader
+	pos   position // Where the last line read starts.
+	next  int64    // Offset of the line after it.
+	root  *node
+	cb    func(value string, err error)
+
+	stopped bool // See stop.
+}
+
+func newUnflattener(r io.Reader) *unflattener {
+	bio, ok := r.(*bufio.Reader)
+	if !ok {
+		bio = bufio.NewReader(r)
+	}
+	return &unflattener{input: bio}
+}
+
+func (u *unflattener) run(cb func(value string, err error)) {
+	u.cb = cb
+	for {
+		line, err := u.input.ReadString('\n')
+		if line != "" {
+			u.pos = position{offset: u.next, line: u.pos.line + 1, column: 1}
+			u.next += int64(len(line))
+			if u.unflattenLine(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")) || u.stopped {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = u.errorf("could not read input: %v", err)
+			return
+		}
+	}
+	u.emit()
+}
+
+// stop makes run return after the line it's on, dropping the value
+// being built, if any. The callback calls it when there's no point in
+// going on.
+func (u *unflattener) stop() {
+	u.stopped = true
+}
+
+// For convenience in unit tests.
+func (u *unflattener) collect() (output []string, err error) {
+	u.run(func(value string, e error) {
+		if e != nil {
+			err = e
+			return
+		}
+		output = append(output, value)
+	})
+	return
+}
+
+func (u *unflattener) errorf(format string, a ...interface{}) (errored bool) {
+	u.cb("", &syntaxError{msg: fmt.Sprintf(format, a...), pos: u.pos})
+	return true
+}
+
+// emit passes the value being rebuilt, if any, to the callback.
+func (u *unflattener) emit() {
+	if u.root == nil {
+		return
+	}
+	var b bytes.Buffer
+	u.root.writeTo(&b)
+	u.root = nil
+	u.cb(b.String(), nil)
+}
+
+func (u *unflattener) unflattenLine(line string) (errored bool) {
+	if line == "" {
+		return false
+	}
+	segs, rest, err := parsePathPrefix(line)
+	if err != nil {
+		return u.errorf("%v", err)
+	}
+	if !strings.HasPrefix(rest, "\t") {
+		return u.errorf("expected tab after path, got: %q", rest)
+	}
+	value := rest[1:]
+	// Ignore any further columns, e.g., the types printed with -T.
+	if i := strings.IndexByte(value, '\t'); i != -1 {
+		value = value[:i]
+	}
+	kind := nodeScalar
+	switch value {
+	case "{}":
+		kind = nodeObject
+	case "[]":
+		kind = nodeArray
+	case "":
+		return u.errorf("missing value")
+	}
+	// Ignore the lines that end containers, printed with -c.
+	if value[0] == '}' || value[0] == ']' {
+		return false
+	}
+	if len(segs) == 0 {
+		u.emit()
+		u.root = newNode(kind)
+		if kind == nodeScalar {
+			u.root.value = value
+		}
+		return false
+	}
+	if u.root == nil {
+		u.root = newNode(kindFor(segs[0]))
+	}
+	n := u.root
+	for i, seg := range segs {
+		childKind := kind
+		if i < len(segs)-1 {
+			childKind = kindFor(segs[i+1])
+		}
+		c, err := n.child(seg, childKind)
+		if err != nil {
+			return u.errorf("%v", err)
+		}
+		n = c
+	}
+	if n.kind != kind {
+		return u.errorf("conflicting values for path %s", line[:len(line)-len(rest)])
+	}
+	if kind == nodeScalar {
+		if n.value != "" {
+			return u.errorf("duplicate value for path %s", line[:len(line)-len(rest)])
+		}
+		n.value = value
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnflattenerRegressions(t *testing.T) {
+	tests := []struct {
+		input  string
+		output []string
+		err    string
+	}{
+		{
+			input:  ".\t{}\n",
+			output: []string{"{}"},
+		},
+		{
+			input:  ".\t42",
+			output: []string{"42"},
+		},
+		{
+			input:  ".\t{}\n.\"fruit\"\t[]\n.\"fruit\"[0]\t{}\n.\"fruit\"[0].\"name\"\t\"banana\"\n.\"fruit\"[1]\t{}\n.\"fruit\"[1].\"name\"\t\"apple\"\n",
+			output: []string{`{"fruit":[{"name":"banana"},{"name":"apple"}]}`},
+		},
+		{
+			// Key order is the order of the input, not sorted.
+			input:  ".\t{}\n.\"z\"\t1\n.\"a\"\t2\n",
+			output: []string{`{"z":1,"a":2}`},
+		},
+		{
+			// Many values, as produced with -m.
+			input:  ".\t1\n.\t[]\n.[0]\t2\n.\t{}\n",
+			output: []string{"1", "[2]", "{}"},
+		},
+		{
+			// Missing container lines are inferred, gaps in indices are closed.
+			input:  ".\"a\"[3].\"b\"\ttrue\n.\"a\"[7]\tnull\r\n",
+			output: []string{`{"a":[{"b":true},null]}`},
+		},
+		{
+			// Types printed with -T are ignored.
+			input:  ".\t[]\tarray\n.[0]\t1\tnumber\n",
+			output: []string{"[1]"},
+		},
+		{
+			// Lines ending containers printed with -c are ignored.
+			input:  ".\t[]\n.[0]\t{}\n.[0]\t}0\n.\t]1\n",
+			output: []string{"[{}]"},
+		},
+		{
+			input: ".\t1\n.[0]\t2\n",
+			err:   "line 2, column 1 (offset 4): index [0] in a non-array",
+		},
+		{
+			input: ".\t{}\n.\"a\"\t1\n.\"a\"\t2\n",
+			err:   `line 3, column 1 (offset 12): duplicate value for path ."a"`,
+		},
+		{
+			input: ".\t{}\n.\"a\"\t1\n.\"a\"\t{}\n",
+			err:   `line 3, column 1 (offset 12): conflicting values for path ."a"`,
+		},
+		{
+			input: ".\"a\" 1\n",
+			err:   `line 1, column 1 (offset 0): expected tab after path, got: " 1"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			output, err := newUnflattener(strings.NewReader(tt.input)).collect()
+			if tt.err != "" {
+				if err == nil {
+					t.Fatalf("got nil, want %q", tt.err)
+				}
+				if got := err.Error(); got != tt.err {
+					t.Errorf("got %q, want %q", got, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.output, output); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// Flattening and then unflattening must give back the same value.
+func TestUnflattenerRoundTrip(t *testing.T) {
+	f := func(input jsonValue) bool {
+		var flat strings.Builder
+		for _, p := range newFlattener(strings.NewReader(string(input))).collect() {
+			if p.err != nil {
+				t.Fatal(p.err)
+			}
+			fmt.Fprintf(&flat, "%s\t%s\n", p.path, p.value)
+		}
+		output, err := newUnflattener(strings.NewReader(flat.String())).collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output) != 1 {
+			t.Logf("got %d values, want 1", len(output))
+			return false
+		}
+		var want, got interface{}
+		if err := json.Unmarshal([]byte(input), &want); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(output[0]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Logf("got %s, want %s", output[0], input)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{input: `""`, output: ""},
+		{input: `"plain"`, output: "plain"},
+		{input: `"\" \\ \/ \b \f \n \r \t"`, output: "\" \\ / \b \f \n \r \t"},
+		{input: `"caf\u00e9"`, output: "café"},
+		{input: `"😀"`, output: "😀"},
+		// Lenient with what the lexer accepts when not validating.
+		{input: `"\q"`, output: `\q`},
+		{input: `"\u12"`, output: `\u12`},
+		{input: `"\ud83d!"`, output: "�!"},
+		{input: `"\ude00"`, output: "�"},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			if got := unquote(tt.input); got != tt.output {
+				t.Errorf("got %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+// For valid input, unquote must agree with the standard library.
+// Invalid UTF-8 is left alone by unquote, while the standard library
+// replaces it, so it's skipped.
+func TestUnquoteConformance(t *testing.T) {
+	f := func(input quotedString) bool {
+		var want string
+		if err := json.Unmarshal([]byte(input), &want); err != nil || !utf8.ValidString(string(input)) {
+			return true
+		}
+		if got := unquote(string(input)); got != want {
+			t.Logf("got %q, want %q for %q", got, want, input)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+}
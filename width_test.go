@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		input string
+		width int
+	}{
+		{input: "", width: 0},
+		{input: `."name"`, width: 7},
+		{input: "café", width: 4},
+		{input: "cafe\u0301", width: 4},
+		{input: "名前", width: 4},
+		{input: "😀!", width: 3},
+	}
+	for _, tt := range tests {
+		if got := displayWidth(tt.input); got != tt.width {
+			t.Errorf("got %d, want %d for %q", got, tt.width, tt.input)
+		}
+	}
+}